@@ -14,11 +14,48 @@ extern "C" {
 // C字符串类型
 typedef char* GoString;
 
-// 返回值常量
+// 返回值常量，与go_src/errors.go中的错误码一致
 #define MIHOOMO_SUCCESS 0
 #define MIHOOMO_ERROR   1
 #define MIHOOMO_RUNNING 2
 
+// 扩展错误码，详细信息通过GetLastError获取
+#define MIHOOMO_ERR_NOT_RUNNING      3
+#define MIHOOMO_ERR_INVALID_ARGUMENT 4
+#define MIHOOMO_ERR_NOT_INITIALIZED  5
+#define MIHOOMO_ERR_CONFIG_IO        6
+#define MIHOOMO_ERR_CONFIG_PARSE     7
+#define MIHOOMO_ERR_CONFIG_INVALID   8
+#define MIHOOMO_ERR_SERIALIZE        9
+#define MIHOOMO_ERR_TUN_NOT_CREATED  10
+#define MIHOOMO_ERR_TUN_ACTIVE       11
+#define MIHOOMO_ERR_INTERNAL_PANIC   12  // Go侧panic已被捕获，GetLastError中包含调用栈
+#define MIHOOMO_ERR_INVALID_STATE    13  // 当前生命周期状态不允许该操作
+#define MIHOOMO_ERR_BUSY             14  // 核心正在启动/停止/重载
+#define MIHOOMO_ERR_ENGINE_START     15  // mihomo引擎启动失败
+#define MIHOOMO_ERR_RELOAD_FAILED    16  // 配置重载应用失败，详见GetMihomoStatus中的lastReload
+#define MIHOOMO_ERR_LOG_IO           17  // 日志文件读写失败
+#define MIHOOMO_ERR_TUN_PERMISSION   18  // 创建TUN设备需要CAP_NET_ADMIN权限
+#define MIHOOMO_ERR_TUN_UNSUPPORTED  19  // 当前平台不支持由核心创建TUN设备
+#define MIHOOMO_ERR_TUN_DEVICE       20  // TUN设备创建、配置或读写失败
+#define MIHOOMO_ERR_CAPTURE_IO       21  // 抓包文件写入失败
+
+// 日志级别，与go_src/logger中的Level一致
+#define MIHOOMO_LOG_DEBUG  0
+#define MIHOOMO_LOG_INFO   1
+#define MIHOOMO_LOG_WARN   2
+#define MIHOOMO_LOG_ERROR  3
+#define MIHOOMO_LOG_SILENT 4
+
+/**
+ * 日志回调函数类型
+ * @param level 日志级别，MIHOOMO_LOG_*
+ * @param timestamp 毫秒时间戳
+ * @param module 模块名（core/config/tun/dns/engine/host），需要调用者通过FreeString释放
+ * @param message 日志内容，需要调用者通过FreeString释放
+ */
+typedef void (*MihomoLogCallback)(int32_t level, int64_t timestamp, char* module, char* message);
+
 // =============================================================================
 // 核心生命周期管理
 // =============================================================================
@@ -32,25 +69,28 @@ int32_t InitializeCore(GoString configPath);
 
 /**
  * 启动Mihomo代理服务
- * @return 0=成功, 1=已运行, 其他=错误码
+ * @return 0=成功, MIHOOMO_RUNNING=已运行, 其他=错误码
  */
 int32_t StartMihomoProxy();
 
 /**
  * 停止Mihomo代理服务
- * @return 0=成功, 1=未运行, 其他=错误码
+ * @return 0=成功, MIHOOMO_ERR_NOT_RUNNING=未运行, 其他=错误码
  */
 int32_t StopMihomoProxy();
 
 /**
  * 重载配置
+ * 新配置先经过解析和验证，运行中时只应用变化的段落，应用失败自动回滚到旧配置
  * @param configPath 新的配置文件路径，空字符串使用原配置
- * @return 0=成功, 其他=错误码
+ * @return 0=成功, MIHOOMO_ERR_RELOAD_FAILED=应用失败, 其他=错误码
  */
 int32_t ReloadConfig(GoString configPath);
 
 /**
  * 获取当前状态信息
+ * status取值: uninitialized/initialized/starting/running/reloading/stopping/stopped/failed
+ * 同时包含最近一次状态迁移的原因(reason)和迁移历史(transitions)
  * @return JSON格式的状态字符串，需要调用者释放内存
  */
 GoString GetMihomoStatus();
@@ -66,19 +106,83 @@ GoString GetMihomoVersion();
 // =============================================================================
 
 /**
- * 日志回调函数
+ * 注册日志回调，核心日志以结构化记录推送给宿主
+ * 回调在核心的日志线程上异步调用，低于SetLogLevel级别的日志不会推送
+ * 重复注册会替换之前的回调，回调中可以再调用RegisterLogCallback/UnregisterLogCallback
+ * @param callback 日志回调函数
+ * @return 0=成功, MIHOOMO_ERR_INVALID_ARGUMENT=回调为空
+ */
+int32_t RegisterLogCallback(MihomoLogCallback callback);
+
+/**
+ * 注销日志回调，返回后不会再有回调发生（会等待正在进行的回调结束）
+ * 可以在日志回调中调用，此时不等待，当前回调返回后不再有回调
+ * @return 0=成功, 其他=错误码
+ */
+int32_t UnregisterLogCallback();
+
+/**
+ * 宿主写入一条日志，以module=host进入核心日志管道
  * @param logLevel 日志级别
  * @param message 日志消息
  */
 void LogCallback(GoString logLevel, GoString message);
 
 /**
- * 设置日志级别
- * @param level 日志级别字符串
- * @return 0=成功, 其他=错误码
+ * 设置日志级别，同时作用于标准输出和日志回调
+ * @param level 日志级别: debug/info/warn/error/silent
+ * @return 0=成功, MIHOOMO_ERR_INVALID_ARGUMENT=未知级别, 其他=错误码
  */
 int32_t SetLogLevel(GoString level);
 
+/**
+ * 设置日志输出格式
+ * text: "2006-01-02 15:04:05.000 INFO  [core] 内容"
+ * json: {"level":"info","timestamp":1700000000000,"module":"core","message":"内容"}
+ * @param format 日志格式: text/json
+ * @return 0=成功, MIHOOMO_ERR_INVALID_ARGUMENT=未知格式, 其他=错误码
+ */
+int32_t SetLogFormat(GoString format);
+
+/**
+ * 查询核心缓存的最近日志（最多保留2000条）
+ * 格式: {"logs":[{"seq":1,"level":"info","timestamp":1700000000000,"module":"core","message":"..."}],
+ *        "oldestSeq":1,"latestSeq":42,"hasMore":false}
+ * 翻页时以上次结果最后一条的seq作为sinceSeq继续查询，直到hasMore为false
+ * 只缓存通过SetLogLevel过滤的日志
+ * @param sinceSeq 只返回序号大于该值的日志，0表示从最旧的开始
+ * @param level 最低级别，空字符串表示全部
+ * @param limit 最多返回条数，<=0时默认200
+ * @return JSON格式的日志列表，需要调用者释放内存
+ */
+GoString GetRecentLogs(int64_t sinceSeq, GoString level, int32_t limit);
+
+/**
+ * 设置日志目录，开启文件日志（当前文件为mihomo-core.log）
+ * @param dir 日志目录，不存在时自动创建；空字符串关闭文件日志
+ * @return 0=成功, MIHOOMO_ERR_LOG_IO=无法创建或打开日志文件, 其他=错误码
+ */
+int32_t SetLogDirectory(GoString dir);
+
+/**
+ * 设置日志文件轮转参数，文件日志已开启时立即生效
+ * 默认: 10MB, 24小时, 保留5个, 压缩
+ * @param maxSizeMB 单个文件最大MB数，0使用默认值
+ * @param maxAgeHours 单个文件最长写入小时数，0表示不按时间轮转
+ * @param maxFiles 保留的历史文件数，0使用默认值
+ * @param compress 非0时gzip压缩历史文件
+ * @return 0=成功, MIHOOMO_ERR_INVALID_ARGUMENT=参数为负, 其他=错误码
+ */
+int32_t SetLogRotation(int32_t maxSizeMB, int32_t maxAgeHours, int32_t maxFiles, int32_t compress);
+
+/**
+ * 将日志文件和当前状态(status.json)打包为zip，用于问题反馈
+ * data字段: {"path":"/path/mihomo-logs-20240101-120000.zip","files":["mihomo-core.log"],"size":1024}
+ * @param outputPath 输出路径，空字符串时写入日志目录
+ * @return JSON格式的结果，需要调用者释放内存
+ */
+GoString ExportLogBundle(GoString outputPath);
+
 /**
  * Hello World测试函数
  * @return 测试消息字符串，需要调用者释放内存
@@ -91,35 +195,181 @@ GoString HelloWorld();
 
 /**
  * 创建TUN接口
- * @param tunName TUN接口名称
- * @return 0=成功, 其他=错误码
+ * Linux桌面端通过/dev/net/tun创建接口，应用SetTunInterface设置的MTU和地址并启用
+ * @param tunName TUN接口名称，空字符串使用SetTunInterface设置的名称或由内核分配
+ * @return 0=成功, MIHOOMO_ERR_TUN_ACTIVE=已存在, MIHOOMO_ERR_BUSY=上一个接口正在停止,
+ *         MIHOOMO_ERR_TUN_PERMISSION=缺少CAP_NET_ADMIN, MIHOOMO_ERR_TUN_UNSUPPORTED=当前平台不支持,
+ *         MIHOOMO_ERR_TUN_DEVICE=创建失败
  */
 int32_t TunCreate(GoString tunName);
 
+/**
+ * 接管宿主创建的TUN文件描述符（Android VpnService.establish()的detachFd()、iOS utun等）
+ * 接管后由核心读写IP数据包，TunStop时关闭该fd，宿主不能再使用或关闭它
+ * 核心无法从fd得知接口地址，TUN劫持的AAAA应答按SetTunInterface记录的地址判断：宿主需用SetTunInterface
+ * 传入与VpnService.Builder相同的地址（只记录，不修改设备），未传入时视为只有IPv4，AAAA查询返回空应答
+ * @param fd TUN文件描述符
+ * @param mtu 接口MTU，<=0时默认1500
+ * @return 0=成功, MIHOOMO_ERR_TUN_ACTIVE=已有TUN接口, MIHOOMO_ERR_BUSY=上一个接口正在停止,
+ *         MIHOOMO_ERR_INVALID_ARGUMENT=参数无效, MIHOOMO_ERR_TUN_DEVICE=fd不可用
+ */
+int32_t TunAttachFd(int32_t fd, int32_t mtu);
+
 /**
  * 启动TUN流量处理
- * @return 0=成功, 1=代理未运行, 其他=错误码
+ * 数据包交给内置用户态协议栈，TCP/UDP连接按代理规则转发；开启了SetTunAutoRoute时先安装自动路由
+ * @return 0=成功, MIHOOMO_ERR_TUN_NOT_CREATED=接口未创建, MIHOOMO_ERR_NOT_RUNNING=代理未运行,
+ *         MIHOOMO_RUNNING=已在处理, MIHOOMO_ERR_TUN_PERMISSION=安装路由权限不足, 其他=错误码
  */
 int32_t TunStart();
 
 /**
- * 停止TUN流量处理
- * @return 0=成功, 其他=错误码
+ * 停止TUN流量处理并销毁TUN接口，经TUN建立的连接全部断开，自动路由在关闭接口前撤销
+ * 阻塞到数据包读写和UDP处理goroutine全部退出，之后最多再等待5秒让TCP连接的处理goroutine结束
+ * 超过5秒时仍返回0，未结束的处理goroutine留在后台，随连接远端一侧关闭而退出，不再读写已关闭的设备，
+ * 此时可以立即重新创建TUN接口；未结束的连接数见GetTunStats的lastShutdown.pendingConnections（completed为false）
+ * @return 0=成功, MIHOOMO_ERR_NOT_RUNNING=接口未创建, MIHOOMO_ERR_BUSY=正在停止, 其他=错误码
  */
 int32_t TunStop();
 
+// -----------------------------------------------------------------------------
+// 宿主收发数据包（二进制接口）
+// 只适用于TunAttachPacketFlow创建的设备，TunCreate/TunAttachFd的设备由核心直接读写
+// 缓冲区由调用者分配和释放；返回int32_t计数/长度的函数，<0时为负的错误码
+// -----------------------------------------------------------------------------
+
+/**
+ * 创建宿主队列TUN设备，用于iOS NEPacketTunnelFlow等没有fd的场景
+ * 与TunAttachFd相同，IPv6地址需通过SetTunInterface告知核心，否则AAAA查询返回空应答
+ * @param mtu 接口MTU，<=0时默认1500
+ * @return 0=成功, MIHOOMO_ERR_TUN_ACTIVE=已有TUN接口, MIHOOMO_ERR_BUSY=上一个接口正在停止, 其他=错误码
+ */
+int32_t TunAttachPacketFlow(int32_t mtu);
+
+/**
+ * 送入一个从系统读到的IP包，返回前数据已复制
+ * @param data 数据包
+ * @param length 数据包长度，不能超过MTU
+ * @return 0=成功, MIHOOMO_ERR_BUSY=入站队列已满（包被丢弃）, 其他=错误码
+ */
+int32_t TunWritePacketBytes(uint8_t* data, int32_t length);
+
+/**
+ * 批量送入IP包
+ * @param buffer 连续存放的数据包
+ * @param sizes 每个包的长度
+ * @param count 包数
+ * @return 被接受的包数（队列满时后续包被丢弃），<0为负的错误码
+ */
+int32_t TunWritePackets(uint8_t* buffer, int32_t* sizes, int32_t count);
+
 /**
- * 从TUN读取数据包
- * @return JSON格式的数据包信息，需要调用者释放内存
+ * 读取一个需要写入系统的IP包
+ * @param buffer 接收缓冲区，不能小于MTU
+ * @param bufferSize 缓冲区大小
+ * @param timeoutMs 等待毫秒数，0=不等待，<0=一直等待到有包或TUN关闭
+ * @return 包长度，0=超时内没有数据包，<0为负的错误码
  */
-GoString TunReadPacket();
+int32_t TunReadPacketBytes(uint8_t* buffer, int32_t bufferSize, int32_t timeoutMs);
 
 /**
- * 向TUN写入数据包
- * @param packetData JSON格式的数据包信息
+ * 批量读取IP包，只有第一个包会等待，之后取完已就绪的包立即返回
+ * @param buffer 接收缓冲区，包连续写入，不能小于MTU
+ * @param bufferSize 缓冲区大小
+ * @param sizes 输出每个包的长度，至少maxPackets个元素
+ * @param maxPackets 最多读取的包数
+ * @param timeoutMs 等待第一个包的毫秒数，0=不等待，<0=一直等待
+ * @return 读到的包数，<0为负的错误码
+ */
+int32_t TunReadPackets(uint8_t* buffer, int32_t bufferSize, int32_t* sizes, int32_t maxPackets, int32_t timeoutMs);
+
+/**
+ * 设置TUN接口参数，在TunCreate之前调用；接口已创建时立即应用
+ * TunAttachFd/TunAttachPacketFlow的设备由宿主配置，这里只记录地址用于AAAA应答，前后调用均可
+ * @param interfaceName TUN接口名称
+ * @param mtu MTU字符串，空字符串默认1500
+ * @param address 接口地址，逗号分隔，每个IP版本最多一个，如"198.18.0.1/30,fdfe:dcba:9876::1/126"为双栈，
+ *                空字符串使用"198.18.0.1/30"；没有IPv6地址时TUN劫持的AAAA查询返回空应答
+ * @return 0=成功, MIHOOMO_ERR_INVALID_ARGUMENT=参数无效, 其他=错误码
+ */
+int32_t SetTunInterface(GoString interfaceName, GoString mtu, GoString address);
+
+/**
+ * 获取TUN流量统计
+ * @return JSON格式的统计信息，需要调用者释放内存
+ *         {"interface","active","started","stopping","packetsIn","packetsOut","bytesIn","bytesOut",
+ *          "uptime"(秒),"startTime","dnsHijack":["any:53"],"dnsHijacked"(已劫持的DNS查询数),
+ *          "appFilter":{"include-package","exclude-package","include-uid","exclude-uid"}(设置了分应用时),
+ *          "appBypassed"(按分应用列表改走DIRECT的连接数),
+ *          "autoRoute":{"interface","table","priority","fwmark","routes","bypassUid","strictRoute"}(已安装自动路由时),
+ *          "leakProtection"(strictRoute阻断规则是否生效，未经过TUN的出站流量被拒绝),
+ *          "lastShutdown":{"interface","time","durationMs","completed","pendingConnections"}}
+ */
+GoString GetTunStats();
+
+/**
+ * 重置TUN统计
  * @return 0=成功, 其他=错误码
  */
-int32_t TunWritePacket(GoString packetData);
+int32_t ResetTunStats();
+
+/**
+ * 设置TUN模式下劫持的DNS目标，发往这些地址的查询由核心DNS模块应答，使域名规则和fake-ip生效
+ * 仅在配置开启dns时生效，修改后对新建连接应用
+ * @param targets 逗号分隔的目标，如"any:53"、"udp://8.8.8.8:53,tcp://any:53"，any匹配任意地址，
+ *                可加udp://或tcp://只劫持该协议；空字符串关闭劫持，默认"any:53"
+ * @return 0=成功, MIHOOMO_ERR_INVALID_ARGUMENT=目标格式无效, 其他=错误码
+ */
+int32_t SetTunDNSHijack(GoString targets);
+
+/**
+ * 设置TUN自动路由（仅Linux桌面端），TunStart时通过netlink安装、TunStop时撤销，TUN已启动时立即重新安装
+ * 在专用路由表中添加指向TUN的路由，并添加策略规则：priority为lookup main suppress_prefixlength 0
+ * （本机网段仍走主路由表），priority+1为分应用列表中不经过代理的UID（uidrange lookup main），
+ * priority+2为not fwmark <mark> lookup <table>；核心出站连接使用配置的
+ * routing-mark，未配置时使用fwmark，从而不会再次进入TUN。priority开始的10个优先级保留给自动路由
+ * 开启时先清理该优先级区间的规则和路由表中的路由，用于核心异常退出后的恢复
+ * strictRoute防泄漏：priority+9为not fwmark <mark> unreachable（IPv4和IPv6都添加），TUN接口消失
+ * （核心崩溃、重启）或重新安装规则期间，未带fwmark的流量被拒绝而不是回落到主路由表；TUN没有地址的
+ * IP版本同样被阻断。崩溃后残留的阻断规则在再次开启strictRoute时保留到TunStart，TunStop或关闭自动路由时撤销
+ * 主路由表中比默认路由更具体的本机网段和分应用排除的UID不受阻断；strictRoute只能与默认路由一起使用，
+ * routes包含其他网段时返回MIHOOMO_ERR_INVALID_ARGUMENT
+ * @param options JSON {"table":2022,"priority":9000,"fwmark":2158,"routes":["0.0.0.0/0","::/0"],
+ *                "strictRoute":false}，字段可省略（使用示例中的默认值，routes默认按TUN地址的IP版本），空字符串关闭
+ * @return 0=成功, MIHOOMO_ERR_INVALID_ARGUMENT=参数无效, MIHOOMO_ERR_TUN_PERMISSION=权限不足,
+ *         MIHOOMO_ERR_TUN_UNSUPPORTED=当前平台不支持（TUN启动时）, 其他=错误码
+ */
+int32_t SetTunAutoRoute(GoString options);
+
+/**
+ * 设置TUN分应用代理，同时设置include和exclude时只代理在include中且不在exclude中的应用
+ * - Linux：自动路由为不经过代理的UID添加uidrange规则，这些应用的流量不进入TUN
+ * - Android：宿主需把同样的包名交给VpnService.Builder的addAllowedApplication/addDisallowedApplication
+ * 仍进入TUN的连接按所属应用匹配，不经过代理的改走DIRECT；设置了include时找不到所属应用的连接也改走DIRECT
+ * 所属应用的查找：Linux通过sock_diag按连接四元组查询本机套接字的UID；Android由宿主通过gomobile接口
+ * SetConnectionOwnerResolver提供UID和包名，未注册时所有连接都视为找不到所属应用
+ * @param options JSON {"include-package":[],"exclude-package":[],"include-uid":[],"exclude-uid":[]}，
+ *                包名仅Android，UID仅Linux和Android；空字符串或列表全部为空时所有应用经过代理
+ * @return 0=成功, MIHOOMO_ERR_INVALID_ARGUMENT=参数无效, MIHOOMO_ERR_TUN_UNSUPPORTED=当前平台不支持该列表,
+ *         其他=错误码（重新安装自动路由失败）
+ */
+int32_t SetTunAppFilter(GoString options);
+
+/**
+ * 获取fake-ip状态，配置dns.enhanced-mode为fake-ip时由dns.fake-ip-range分配地址
+ * 配置开启experimental.cache-file时映射持久化到配置目录下的cache.db，重启后沿用；cache-file的路径
+ * 只能是该文件，配置其他路径时加载和重载配置返回MIHOOMO_ERR_CONFIG_INVALID
+ * persistent为false时映射只在内存中，cachePath为空
+ * @return JSON {"enabled","range","gateway","persistent","cachePath"}，引擎未运行时enabled为false，
+ *         需要调用者释放内存
+ */
+GoString GetFakeIPStatus();
+
+/**
+ * 清空fake-ip映射（包括缓存文件中的记录），之后从地址池开头重新分配
+ * @return 0=成功, MIHOOMO_ERR_NOT_RUNNING=fake-ip未启用, 其他=错误码
+ */
+int32_t FlushFakeIPCache();
 
 // =============================================================================
 // 流量统计
@@ -131,6 +381,44 @@ int32_t TunWritePacket(GoString packetData);
  */
 GoString GetTrafficStats();
 
+/**
+ * 获取最近10分钟每秒的TUN速率，TUN启动期间由核心每秒采样
+ * @param sinceTime Unix秒，只返回之后的采样，0返回全部，可用上次最后一条的time增量获取
+ * @return JSON {"interval":1,"capacity":600,"samples":[{"time","upload","download","packetsIn","packetsOut"}]}，
+ *         速率单位为字节/秒和包/秒，需要调用者释放内存
+ */
+GoString GetTrafficHistory(int64_t sinceTime);
+
+/**
+ * 获取按协议、IP版本和目的地址拆分的TUN流量
+ * 协议(tcp/udp/icmp/other)和IP版本(ipv4/ipv6)按IP包计数；目的地址按TCP/UDP连接计数，字节数为载荷，
+ * 按上下行总字节数降序，能通过fake-ip或DNS映射查到域名时带host
+ * @param topN 返回的目的地址数，<=0时默认10
+ * @return JSON {"protocols":{"tcp":{"packetsIn","packetsOut","bytesIn","bytesOut"},...},"ipVersions":{...},
+ *         "destinations":[{"ip","host","connections","upload","download"}],"destinationCount","startTime"}，
+ *         需要调用者释放内存
+ */
+GoString GetTunTrafficBreakdown(int32_t topN);
+
+/**
+ * 开始抓取TUN数据包，写入pcapng文件（链路类型为原始IP），可用Wireshark打开
+ * 从TUN读出的包标记为outbound，写回TUN的包标记为inbound
+ * @param outputPath 输出路径，空字符串时写入日志目录
+ * @param filter 过滤条件，空字符串抓取全部，支持 tcp udp icmp ip ip6、[src|dst] host <IP>、
+ *               [src|dst] net <CIDR>、[src|dst] port <端口>，可加not，用and/or组合，如"udp and port 53 or host 1.1.1.1"
+ * @param maxSizeMB 文件大小上限，<=0时100MB，达到上限后自动停止
+ * @return 0=成功, MIHOOMO_RUNNING=已在抓包, MIHOOMO_ERR_INVALID_ARGUMENT=过滤条件无效,
+ *         MIHOOMO_ERR_CAPTURE_IO=文件创建失败, 其他=错误码
+ */
+int32_t TunStartCapture(GoString outputPath, GoString filter, int32_t maxSizeMB);
+
+/**
+ * 停止TUN抓包，抓包已因达到上限自动停止时返回那次的结果
+ * data字段: {"path","filter","packets","skipped","size","maxSize","truncated","error","startTime","durationMs"}
+ * @return JSON格式的结果，没有抓包时code为MIHOOMO_ERR_NOT_RUNNING，需要调用者释放内存
+ */
+GoString TunStopCapture();
+
 /**
  * 重置流量统计
  * @return 0=成功, 其他=错误码
@@ -154,6 +442,12 @@ void FreeString(GoString str);
  */
 int32_t GetStringLength(GoString str);
 
+/**
+ * 释放TUN相关字符串内存，等同于FreeString
+ * @param str 要释放的字符串指针
+ */
+void FreeTunString(GoString str);
+
 // =============================================================================
 // 配置管理
 // =============================================================================
@@ -196,7 +490,7 @@ GoString ConfigToJSON(GoString configYAML);
 /**
  * 从JSON配置转换为YAML
  * @param configJSON JSON格式的配置数据
- * @return YAML格式的结果，需要调用者释放内存
+ * @return JSON格式的结果，data字段为YAML文本，需要调用者释放内存
  */
 GoString ConfigFromJSON(GoString configJSON);
 
@@ -213,18 +507,77 @@ GoString ConfigListProfiles(GoString dirPath);
  */
 GoString ConfigHotReload();
 
+/**
+ * 重载指定配置文件
+ * data字段为重载报告: {"path":"...","applied":"partial","changed":["rules"],"unchanged":[...],"rolledBack":false,...}
+ * applied取值: none=无变化, deferred=核心未运行, partial=只应用变化段落, full=整体重新应用
+ * @param filePath 配置文件路径，为空时沿用当前配置
+ * @return JSON格式的结果，需要调用者释放内存
+ */
+GoString ConfigReload(GoString filePath);
+
+/**
+ * 加载YAML配置文件
+ * @param configPath 配置文件路径，为空时使用默认路径
+ * @return 0=成功, 其他=错误码
+ */
+int32_t LoadConfigFile(GoString configPath);
+
+/**
+ * 保存配置到YAML文件
+ * @param configPath 保存路径，为空时使用当前配置路径
+ * @param configData JSON格式的配置数据
+ * @return 0=成功, 其他=错误码
+ */
+int32_t SaveConfigFile(GoString configPath, GoString configData);
+
+/**
+ * 获取配置值
+ * @param key 配置键，支持 "dns.enable" 形式的嵌套键
+ * @return JSON格式的配置值，不存在时为空字符串，需要调用者释放内存
+ */
+GoString GetConfigValue(GoString key);
+
+/**
+ * 设置配置值
+ * @param key 配置键，支持嵌套键
+ * @param value JSON格式的配置值
+ * @return 0=成功, 其他=错误码
+ */
+int32_t SetConfigValue(GoString key, GoString value);
+
+/**
+ * 获取所有配置
+ * @return JSON格式的配置数据，需要调用者释放内存
+ */
+GoString GetAllConfig();
+
+/**
+ * 获取当前配置路径
+ * @return 配置文件路径，需要调用者释放内存
+ */
+GoString GetConfigPath();
+
+/**
+ * 列出顶层配置键
+ * @return JSON数组，需要调用者释放内存
+ */
+GoString ListConfigKeys();
+
 // =============================================================================
 // 错误处理
 // =============================================================================
 
 /**
- * 获取最后的错误信息
- * @return 错误信息字符串，需要调用者释放内存
+ * 获取当前线程最后的错误信息
+ * 格式: {"code":7,"name":"CONFIG_PARSE","message":"...","function":"loadConfigFile","timestamp":1700000000000}
+ * 无错误时code为0
+ * @return JSON格式的错误信息，需要调用者释放内存
  */
 GoString GetLastError();
 
 /**
- * 清除错误信息
+ * 清除当前线程的错误信息
  */
 void ClearError();
 
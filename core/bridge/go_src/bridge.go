@@ -5,167 +5,272 @@ package main
 
 import (
 	"C"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/mihomo-flutter-cross/core/logger"
 )
 
 // 全局状态管理
 var (
 	mu        sync.RWMutex
-	isRunning bool
 	configMap = make(map[string]string)
 )
 
-//export InitializeCore
 // 初始化Mihomo核心
-func InitializeCore(configPath string) int {
-	mu.Lock()
-	defer mu.Unlock()
+//
+//export InitializeCore
+func InitializeCore(cConfigPath *C.char) (ret int32) {
+	defer recoverCode(&ret)
 
+	configPath := C.GoString(cConfigPath)
 	if configPath == "" {
 		configPath = "default"
 	}
 
+	if err := lifecycle.transition(StateInitialized, "InitializeCore: "+configPath); err != nil {
+		return setLastError(err)
+	}
+
+	mu.Lock()
 	configMap["path"] = configPath
-	fmt.Printf("🎉 初始化核心成功! 配置: %s\n", configPath)
-	return 0 // 成功
+	mu.Unlock()
+
+	coreLog.Infof("初始化核心成功! 配置: %s", configPath)
+	return CodeSuccess
 }
 
-//export StartMihomoProxy
 // 启动Mihomo代理服务
-func StartMihomoProxy() int {
-	mu.Lock()
-	defer mu.Unlock()
+//
+//export StartMihomoProxy
+func StartMihomoProxy() (ret int32) {
+	defer recoverCode(&ret)
 
-	if isRunning {
-		fmt.Println("⚠️  代理已经在运行中")
-		return 1 // 已运行
+	if err := lifecycle.transition(StateStarting, "StartMihomoProxy"); err != nil {
+		return setLastError(err)
 	}
+	defer failOnPanic(StateStarting)
 
-	isRunning = true
-	fmt.Println("🚀 启动 Mihomo 代理...")
+	coreLog.Infof("启动 Mihomo 代理...")
 
-	// 启动代理服务（在实际实现中，这里会启动真实的代理逻辑）
-	go func() {
-		for i := 0; i < 5; i++ {
-			time.Sleep(1 * time.Second)
-			fmt.Printf("📊 代理运行中... (%d/5)\n", i+1)
-		}
-		fmt.Println("✅ Mihomo 代理启动完成")
-	}()
+	if err := startEngine(currentConfigPath()); err != nil {
+		lifecycle.transition(StateFailed, "启动失败: "+err.Error())
+		return setLastError(err)
+	}
 
-	return 0 // 成功
+	lifecycle.transition(StateRunning, "mihomo "+engineVersion()+" 启动完成")
+	coreLog.Infof("Mihomo 代理启动完成 (mihomo %s)", engineVersion())
+	return CodeSuccess
 }
 
-//export StopMihomoProxy
 // 停止Mihomo代理服务
-func StopMihomoProxy() int {
-	mu.Lock()
-	defer mu.Unlock()
+//
+//export StopMihomoProxy
+func StopMihomoProxy() (ret int32) {
+	defer recoverCode(&ret)
+
+	if err := lifecycle.transition(StateStopping, "StopMihomoProxy"); err != nil {
+		return setLastError(err)
+	}
+	defer failOnPanic(StateStopping)
 
-	if !isRunning {
-		fmt.Println("⚠️  代理未在运行")
-		return 1 // 未运行
+	coreLog.Infof("停止 Mihomo 代理...")
+
+	if err := stopEngine(); err != nil {
+		lifecycle.transition(StateFailed, "停止失败: "+err.Error())
+		return setLastError(err)
 	}
 
-	isRunning = false
-	fmt.Println("🛑 停止 Mihomo 代理...")
-	return 0 // 成功
+	lifecycle.transition(StateStopped, "已停止")
+	return CodeSuccess
 }
 
-//export ReloadConfig
 // 重载配置
-func ReloadConfig(configPath string) int {
-	mu.Lock()
-	defer mu.Unlock()
+//
+//export ReloadConfig
+func ReloadConfig(cConfigPath *C.char) (ret int32) {
+	defer recoverCode(&ret)
 
+	if _, err := reloadCore(C.GoString(cConfigPath)); err != nil {
+		return setLastError(err)
+	}
+	return CodeSuccess
+}
+
+// reloadCore 按路径重载核心配置，空路径表示沿用原配置
+// 运行中时应用到引擎，否则只验证并记录路径，下次启动生效
+func reloadCore(configPath string) (*ReloadReport, error) {
 	if configPath != "" {
-		configMap["path"] = configPath
-		fmt.Printf("🔄 配置重载: %s\n", configPath)
+		coreLog.Infof("配置重载: %s", configPath)
 	} else {
-		fmt.Println("🔄 配置重载（使用原配置）")
+		coreLog.Infof("配置重载（使用原配置）")
 	}
 
-	if isRunning {
-		fmt.Println("✅ 动态重载成功")
-	} else {
-		fmt.Println("⚠️  代理未运行，重载将在下次启动时生效")
+	switch lifecycle.current() {
+	case StateRunning:
+	case StateStarting, StateStopping, StateReloading:
+		return nil, newError(CodeBusy, "核心正在%s，无法重载配置", lifecycle.current())
+	default:
+		target := configPath
+		if target == "" {
+			target = currentConfigPath()
+		}
+		report, err := validateReloadTarget(target)
+		if err != nil {
+			return report, err
+		}
+		if configPath != "" {
+			mu.Lock()
+			configMap["path"] = configPath
+			mu.Unlock()
+		}
+		coreLog.Warnf("代理未运行，重载将在下次启动时生效")
+		return report, nil
+	}
+
+	if err := lifecycle.transition(StateReloading, "ReloadConfig"); err != nil {
+		return nil, err
+	}
+	defer failOnPanic(StateReloading)
+
+	report, err := reloadEngine(configPath)
+	if err != nil {
+		if report.RolledBack {
+			lifecycle.transition(StateRunning, "重载失败，已回滚: "+report.Error)
+		} else if len(report.Changed) > 0 {
+			lifecycle.transition(StateFailed, "重载失败且回滚失败: "+err.Error())
+		} else {
+			lifecycle.transition(StateRunning, "重载被拒绝: "+err.Error())
+		}
+		return report, err
+	}
+
+	if configPath != "" {
+		mu.Lock()
+		configMap["path"] = configPath
+		mu.Unlock()
 	}
 
-	return 0 // 成功
+	lifecycle.transition(StateRunning, fmt.Sprintf("配置重载完成 (%s: %v)", report.Applied, report.Changed))
+	coreLog.Infof("动态重载成功")
+	return report, nil
 }
 
-//export GetMihomoStatus
-// 获取当前状态信息
-func GetMihomoStatus() *C.char {
+// currentConfigPath InitializeCore记录的配置路径
+func currentConfigPath() string {
 	mu.RLock()
 	defer mu.RUnlock()
 
-	var status string
-	if isRunning {
-		status = "running"
-	} else {
-		status = "stopped"
-	}
-
 	configPath, exists := configMap["path"]
 	if !exists {
 		configPath = "default"
 	}
+	return configPath
+}
 
-	result := fmt.Sprintf(`{"status": "%s", "config": "%s", "version": "v0.1.0-alpha"}`, status, configPath)
-	return C.CString(result)
+// coreStatus 汇总生命周期、配置和引擎信息
+func coreStatus() CoreStatus {
+	status := lifecycle.snapshot()
+	status.Config = currentConfigPath()
+	status.Version = "v0.1.0-alpha"
+	status.Engine = engineVersion()
+	status.LastReload = latestReloadReport()
+	return status
+}
+
+// 获取当前状态信息
+//
+//export GetMihomoStatus
+func GetMihomoStatus() (ret *C.char) {
+	defer recoverString(&ret)
+
+	data, err := json.Marshal(coreStatus())
+	if err != nil {
+		setLastError(wrapError(CodeSerialize, err, "状态序列化失败"))
+		return C.CString(`{"status": "unknown"}`)
+	}
+	return C.CString(string(data))
 }
 
-//export GetMihomoVersion
 // 获取版本信息
-func GetMihomoVersion() *C.char {
+//
+//export GetMihomoVersion
+func GetMihomoVersion() (ret *C.char) {
+	defer recoverString(&ret)
+
 	return C.CString("v0.1.0-alpha")
 }
 
+// 宿主写入日志，进入核心日志管道（module为host），与核心日志一起推送给已注册的回调
+//
 //export LogCallback
-// 日志回调函数
-func LogCallback(logLevel, message string) {
-	level := ""
-	switch logLevel {
-	case "info":
-		level = "ℹ️"
-	case "warn":
-		level = "⚠️"
-	case "error":
-		level = "❌"
-	case "debug":
-		level = "🔍"
-	default:
-		level = "📝"
-	}
+func LogCallback(cLogLevel, cMessage *C.char) {
+	defer recoverVoid()
 
-	fmt.Printf("%s [%s] %s\n", level, logLevel, message)
+	level, err := logger.ParseLevel(C.GoString(cLogLevel))
+	if err != nil {
+		level = logger.Info
+	}
+	hostLog.Log(level, "%s", C.GoString(cMessage))
 }
 
+// 设置日志级别，低于该级别的日志不再输出和推送
+//
 //export SetLogLevel
-// 设置日志级别
-func SetLogLevel(level string) int {
+func SetLogLevel(cLevel *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
+	level, err := logger.ParseLevel(C.GoString(cLevel))
+	if err != nil {
+		return setLastError(wrapError(CodeInvalidArgument, err, "设置日志级别失败"))
+	}
+
 	mu.Lock()
-	defer mu.Unlock()
-	configMap["loglevel"] = level
-	fmt.Printf("📝 日志级别设置为: %s\n", level)
-	return 0
+	configMap["loglevel"] = level.String()
+	mu.Unlock()
+
+	logger.SetLevel(level)
+	coreLog.Infof("日志级别设置为: %s", level)
+	return CodeSuccess
+}
+
+// 设置日志输出格式: text/json
+//
+//export SetLogFormat
+func SetLogFormat(cFormat *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
+	format, err := logger.ParseFormat(C.GoString(cFormat))
+	if err != nil {
+		return setLastError(wrapError(CodeInvalidArgument, err, "设置日志格式失败"))
+	}
+
+	mu.Lock()
+	configMap["logformat"] = format.String()
+	mu.Unlock()
+
+	logger.SetFormat(format)
+	coreLog.Infof("日志格式设置为: %s", format)
+	return CodeSuccess
 }
 
-//export HelloWorld
 // Hello World测试函数
-func HelloWorld() *C.char {
+//
+//export HelloWorld
+func HelloWorld() (ret *C.char) {
+	defer recoverString(&ret)
+
 	return C.CString("Hello from Mihomo-Flutter-Cross Bridge!")
 }
 
 // main 函数用于本地测试
 func main() {
-	fmt.Println("🏗️  Mihomo Flutter Cross Bridge 构建测试")
+	coreLog.Infof("Mihomo Flutter Cross Bridge 构建测试")
 
 	// 测试初始化
-	InitializeCore("test.yaml")
+	InitializeCore(C.CString("test.yaml"))
 
 	// 测试启动
 	StartMihomoProxy()
@@ -175,16 +280,16 @@ func main() {
 
 	// 测试状态查询
 	status := GetMihomoStatus()
-	fmt.Printf("📊 状态: %s\n", C.GoString(status))
+	coreLog.Infof("状态: %s", C.GoString(status))
 
 	// 测试停止
 	StopMihomoProxy()
 
-	fmt.Printf("👋 %s\n", HelloWorld())
-	fmt.Printf("📊 版本: %s\n", GetMihomoVersion())
+	coreLog.Infof("%s", C.GoString(HelloWorld()))
+	coreLog.Infof("版本: %s", C.GoString(GetMihomoVersion()))
 
 	// 测试日志
-	LogCallback("info", "系统启动完成")
-	LogCallback("warn", "这是一个测试警告")
-	LogCallback("error", "这是一个测试错误")
-}
\ No newline at end of file
+	LogCallback(C.CString("info"), C.CString("系统启动完成"))
+	LogCallback(C.CString("warn"), C.CString("这是一个测试警告"))
+	LogCallback(C.CString("error"), C.CString("这是一个测试错误"))
+}
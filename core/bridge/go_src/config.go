@@ -1,26 +1,33 @@
 package main
 
 import (
-	"fmt"
-	"sync"
-	"gopkg.in/yaml.v3"
+	"C"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // Config 全局配置结构
 type Config struct {
-mu sync.RWMutex
-Path string `json:"path"`
-Data map[string]interface{} `json:"data"`
+	mu   sync.RWMutex
+	Path string                 `json:"path"`
+	Data map[string]interface{} `json:"data"`
 }
 
 // ConfigInstance 配置单例
 var configInstance *Config
 var configInitOnce sync.Once
 
+// 默认配置文件路径
+const defaultConfigPath = "configs/default.yaml"
+
 // GetConfig 获取配置单例
 func GetConfig() *Config {
 	configInitOnce.Do(func() {
@@ -31,49 +38,74 @@ func GetConfig() *Config {
 	return configInstance
 }
 
-// LoadConfigFile 加载YAML配置文件
-//export LoadConfigFile
-func LoadConfigFile(configPath string) int {
-	config := GetConfig()
-	config.mu.Lock()
-	defer config.mu.Unlock()
+// ConfigResult Config*系列导出函数的统一返回结构
+// 与Dart侧 {'success': bool, 'data': ..., 'error': ...} 约定保持一致
+type ConfigResult struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Code    int32       `json:"code,omitempty"`
+}
+
+// configResult 将结果编码为C字符串，调用者负责通过FreeString释放
+func configResult(data interface{}, err error) *C.char {
+	result := ConfigResult{Success: err == nil, Data: data}
+	if err != nil {
+		result.Error = err.Error()
+		result.Code = setLastError(err)
+	}
 
+	jsonData, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		setLastError(wrapError(CodeSerialize, marshalErr, "结果序列化失败"))
+		jsonData, _ = json.Marshal(ConfigResult{Error: marshalErr.Error(), Code: CodeSerialize})
+	}
+	return C.CString(string(jsonData))
+}
+
+// loadConfigFile 读取并解析YAML配置文件，文件不存在时创建默认配置
+func loadConfigFile(configPath string) error {
 	if configPath == "" {
-		configPath = "configs/default.yaml"
+		configPath = defaultConfigPath
 	}
 
 	// 确保目录存在
 	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
-		fmt.Printf("❌ 创建配置目录失败: %v\n", err)
-		return 1
+		return wrapError(CodeConfigIO, err, "创建配置目录失败")
 	}
 
 	// 读取文件
 	data, err := os.ReadFile(configPath)
-	if err != nil {
+	if os.IsNotExist(err) {
 		// 如果文件不存在，创建默认配置
-		fmt.Printf("⚠️  配置文件不存在，创建默认配置: %s\n", configPath)
+		configLog.Warnf("配置文件不存在，创建默认配置: %s", configPath)
 		return createDefaultConfig(configPath)
 	}
+	if err != nil {
+		return wrapError(CodeConfigIO, err, "读取配置文件失败")
+	}
 
 	// 解析YAML
 	var configData map[string]interface{}
 	if err := yaml.Unmarshal(data, &configData); err != nil {
-		fmt.Printf("❌ YAML解析失败: %v\n", err)
-		return 1
+		return wrapError(CodeConfigParse, err, "YAML解析失败")
+	}
+	if configData == nil {
+		configData = make(map[string]interface{})
 	}
 
+	config := GetConfig()
+	config.mu.Lock()
 	config.Path = configPath
 	config.Data = configData
+	config.mu.Unlock()
 
-	fmt.Printf("✅ 配置文件加载成功: %s\n", configPath)
-	fmt.Printf("📋 配置项数量: %d\n", len(configData))
-	return 0
+	configLog.Infof("配置文件加载成功: %s (%d 项)", configPath, len(configData))
+	return nil
 }
 
-// SaveConfigFile 保存YAML配置文件
-//export SaveConfigFile
-func SaveConfigFile(configPath string, configData string) int {
+// saveConfigFile 将配置序列化为YAML并写入文件
+func saveConfigFile(configPath string, data map[string]interface{}) error {
 	config := GetConfig()
 	config.mu.Lock()
 	defer config.mu.Unlock()
@@ -81,208 +113,232 @@ func SaveConfigFile(configPath string, configData string) int {
 	if configPath == "" {
 		configPath = config.Path
 		if configPath == "" {
-			configPath = "configs/default.yaml"
+			configPath = defaultConfigPath
 		}
 	}
 
-	var data map[string]interface{}
-	if err := json.Unmarshal([]byte(configData), &data); err != nil {
-		fmt.Printf("❌ JSON解析失败: %v\n", err)
-		return 1
-	}
-
 	// 序列化YAML
 	yamlData, err := yaml.Marshal(data)
 	if err != nil {
-		fmt.Printf("❌ YAML序列化失败: %v\n", err)
-		return 1
+		return wrapError(CodeSerialize, err, "YAML序列化失败")
 	}
 
 	// 确保目录存在
 	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
-		fmt.Printf("❌ 创建配置目录失败: %v\n", err)
-		return 1
+		return wrapError(CodeConfigIO, err, "创建配置目录失败")
 	}
 
 	// 写入文件
 	if err := os.WriteFile(configPath, yamlData, 0644); err != nil {
-		fmt.Printf("❌ 保存配置文件失败: %v\n", err)
-		return 1
+		return wrapError(CodeConfigIO, err, "保存配置文件失败")
 	}
 
 	config.Path = configPath
 	config.Data = data
 
-	fmt.Printf("✅ 配置文件保存成功: %s\n", configPath)
-	return 0
+	configLog.Infof("配置文件保存成功: %s", configPath)
+	return nil
+}
+
+// LoadConfigFile 加载YAML配置文件
+//
+//export LoadConfigFile
+func LoadConfigFile(cConfigPath *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
+	if err := loadConfigFile(C.GoString(cConfigPath)); err != nil {
+		return setLastError(err)
+	}
+	return CodeSuccess
+}
+
+// SaveConfigFile 保存YAML配置文件
+//
+//export SaveConfigFile
+func SaveConfigFile(cConfigPath, cConfigData *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(C.GoString(cConfigData)), &data); err != nil {
+		return setLastError(wrapError(CodeInvalidArgument, err, "JSON解析失败"))
+	}
+
+	if err := saveConfigFile(C.GoString(cConfigPath), data); err != nil {
+		return setLastError(err)
+	}
+	return CodeSuccess
 }
 
 // GetConfigValue 获取配置值
+//
 //export GetConfigValue
-func GetConfigValue(key string) string {
+func GetConfigValue(cKey *C.char) (ret *C.char) {
+	defer recoverString(&ret)
+
 	config := GetConfig()
 	config.mu.RLock()
 	defer config.mu.RUnlock()
 
+	key := C.GoString(cKey)
 	if key == "" {
-		return ""
+		return C.CString("")
 	}
 
 	// 解析嵌套键,如 "proxy.servers"
-	keys := strings.Split(key, ".")
-	current := config.Data
-
-	for _, k := range keys {
-		if current == nil {
-			return ""
-		}
-		
-		if mapData, ok := current.(map[string]interface{}); ok {
-			current = mapData[k]
-		} else {
-			current = nil
+	var current interface{} = config.Data
+	for _, k := range strings.Split(key, ".") {
+		mapData, ok := current.(map[string]interface{})
+		if !ok {
+			return C.CString("")
 		}
+		current = mapData[k]
 	}
 
 	if current == nil {
-		return ""
+		return C.CString("")
 	}
 
 	// 转换为JSON字符串
 	jsonData, err := json.Marshal(current)
 	if err != nil {
-		return ""
+		return C.CString("")
 	}
 
-	return string(jsonData)
+	return C.CString(string(jsonData))
 }
 
 // SetConfigValue 设置配置值
+//
 //export SetConfigValue
-func SetConfigValue(key string, value string) int {
+func SetConfigValue(cKey, cValue *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
 	config := GetConfig()
 	config.mu.Lock()
 	defer config.mu.Unlock()
 
+	key := C.GoString(cKey)
+	value := C.GoString(cValue)
 	if key == "" {
-		fmt.Printf("❌ 配置键不能为空\n")
-		return 1
+		return failf(CodeInvalidArgument, "配置键不能为空")
 	}
 
 	var data interface{}
 	if err := json.Unmarshal([]byte(value), &data); err != nil {
-		fmt.Printf("❌ 配置值JSON解析失败: %v\n", err)
-		return 1
+		return setLastError(wrapError(CodeInvalidArgument, err, "配置值JSON解析失败"))
 	}
 
-	// 解析嵌套键
-	keys := strings.Split(key, ".")
-	
 	// 确保数据结构存在
 	if config.Data == nil {
 		config.Data = make(map[string]interface{})
 	}
 
+	// 解析嵌套键
+	keys := strings.Split(key, ".")
 	current := config.Data
 	for i, k := range keys {
 		if i == len(keys)-1 {
 			// 最后一级键，直接设置值
-			if mapData, ok := current.(map[string]interface{}); ok {
-				mapData[k] = data
-			} else {
-				fmt.Printf("❌ 无法在非字典类型中设置值: %s\n", key)
-				return 1
-			}
-		} else {
-			// 中间级键，确保结构存在
-			if mapData, ok := current.(map[string]interface{}); ok {
-				if _, exists := mapData[k]; !exists {
-					mapData[k] = make(map[string]interface{})
-				}
-				current = mapData[k]
-			} else {
-				fmt.Printf("❌ 无法创建嵌套结构: %s\n", key)
-				return 1
-			}
+			current[k] = data
+			break
+		}
+
+		// 中间级键，确保结构存在
+		if _, exists := current[k]; !exists {
+			current[k] = make(map[string]interface{})
+		}
+		next, ok := current[k].(map[string]interface{})
+		if !ok {
+			return failf(CodeInvalidArgument, "无法在非字典类型中设置值: %s", key)
 		}
+		current = next
 	}
 
-	fmt.Printf("✅ 配置值设置成功: %s = %s\n", key, value)
-	return 0
+	configLog.Infof("配置值设置成功: %s = %s", key, value)
+	return CodeSuccess
 }
 
 // GetAllConfig 获取所有配置
+//
 //export GetAllConfig
-func GetAllConfig() string {
+func GetAllConfig() (ret *C.char) {
+	defer recoverString(&ret)
+
 	config := GetConfig()
 	config.mu.RLock()
 	defer config.mu.RUnlock()
 
 	if config.Data == nil {
-		return "{}"
+		return C.CString("{}")
 	}
 
 	jsonData, err := json.Marshal(config.Data)
 	if err != nil {
-		return "{}"
+		return C.CString("{}")
 	}
 
-	return string(jsonData)
+	return C.CString(string(jsonData))
 }
 
 // GetConfigPath 获取当前配置路径
+//
 //export GetConfigPath
-func GetConfigPath() string {
+func GetConfigPath() (ret *C.char) {
+	defer recoverString(&ret)
+
 	config := GetConfig()
 	config.mu.RLock()
 	defer config.mu.RUnlock()
-	return config.Path
+	return C.CString(config.Path)
 }
 
-// createDefaultConfig 创建默认配置
-func createDefaultConfig(configPath string) int {
-	defaultConfig := map[string]interface{}{
+// defaultConfigData 默认配置内容
+func defaultConfigData() map[string]interface{} {
+	return map[string]interface{}{
 		"proxy": map[string]interface{}{
-			"mode": "rule",
-			"log-level": "info",
+			"mode":                "rule",
+			"log-level":           "info",
 			"external-controller": "127.0.0.1:9090",
-			"proxies": []interface{}{},
+			"proxies":             []interface{}{},
 			"proxy-groups": []interface{}{
 				map[string]interface{}{
-					"name": "Auto",
-					"type": "url-test",
-					"url": "http://www.gstatic.com/generate_204",
+					"name":     "Auto",
+					"type":     "url-test",
+					"url":      "http://www.gstatic.com/generate_204",
 					"interval": 300,
-					"proxies": []interface{}{},
+					"proxies":  []interface{}{},
 				},
 			},
-			"rules": []string{
+			"rules": []interface{}{
 				"DOMAIN-SUFFIX,google.com,Auto",
 				"DOMAIN-SUFFIX,github.com,Auto",
 				"MATCH,DIRECT",
 			},
 		},
 		"dns": map[string]interface{}{
-			"enable": true,
-			"ipv6": false,
+			"enable":    true,
+			"ipv6":      true,
 			"use-hosts": true,
-			"nameservers": []string{
+			"nameservers": []interface{}{
 				"8.8.8.8",
 				"1.1.1.1",
 				"223.5.5.5",
 			},
 		},
 	}
+}
+
+// createDefaultConfig 创建默认配置
+func createDefaultConfig(configPath string) error {
+	defaultConfig := defaultConfigData()
 
 	yamlData, err := yaml.Marshal(defaultConfig)
 	if err != nil {
-		fmt.Printf("❌ 默认配置序列化失败: %v\n", err)
-		return 1
+		return wrapError(CodeSerialize, err, "默认配置序列化失败")
 	}
 
 	if err := os.WriteFile(configPath, yamlData, 0644); err != nil {
-		fmt.Printf("❌ 创建默认配置文件失败: %v\n", err)
-		return 1
+		return wrapError(CodeConfigIO, err, "创建默认配置文件失败")
 	}
 
 	config := GetConfig()
@@ -292,30 +348,273 @@ func createDefaultConfig(configPath string) int {
 	config.Path = configPath
 	config.Data = defaultConfig
 
-	fmt.Printf("✅ 默认配置文件创建成功: %s\n", configPath)
-	return 0
+	configLog.Infof("默认配置文件创建成功: %s", configPath)
+	return nil
 }
 
 // ListConfigKeys 列出配置键
+//
 //export ListConfigKeys
-func ListConfigKeys() string {
+func ListConfigKeys() (ret *C.char) {
+	defer recoverString(&ret)
+
 	config := GetConfig()
 	config.mu.RLock()
 	defer config.mu.RUnlock()
 
-	if config.Data == nil {
-		return "[]"
-	}
-
-	var keys []string
+	keys := make([]string, 0, len(config.Data))
 	for key := range config.Data {
 		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
 	jsonData, err := json.Marshal(keys)
 	if err != nil {
-		return "[]"
+		return C.CString("[]")
+	}
+
+	return C.CString(string(jsonData))
+}
+
+// ConfigLoad 加载YAML配置文件并返回解析结果
+//
+//export ConfigLoad
+func ConfigLoad(cFilePath *C.char) (ret *C.char) {
+	defer recoverString(&ret)
+
+	if err := loadConfigFile(C.GoString(cFilePath)); err != nil {
+		return configResult(nil, err)
+	}
+	return configResult(currentConfigSnapshot(), nil)
+}
+
+// ConfigSave 保存JSON格式的配置到YAML文件
+//
+//export ConfigSave
+func ConfigSave(cConfigJSON, cFilePath *C.char) (ret *C.char) {
+	defer recoverString(&ret)
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(C.GoString(cConfigJSON)), &data); err != nil {
+		return configResult(nil, wrapError(CodeInvalidArgument, err, "JSON解析失败"))
+	}
+
+	if err := saveConfigFile(C.GoString(cFilePath), data); err != nil {
+		return configResult(nil, err)
+	}
+	return configResult(map[string]interface{}{"path": GetConfig().currentPath()}, nil)
+}
+
+// ConfigGetCurrent 获取当前配置
+//
+//export ConfigGetCurrent
+func ConfigGetCurrent() (ret *C.char) {
+	defer recoverString(&ret)
+
+	return configResult(currentConfigSnapshot(), nil)
+}
+
+// ConfigValidate 验证配置格式
+//
+//export ConfigValidate
+func ConfigValidate(cConfigJSON *C.char) (ret *C.char) {
+	defer recoverString(&ret)
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(C.GoString(cConfigJSON)), &data); err != nil {
+		return configResult(nil, wrapError(CodeInvalidArgument, err, "JSON解析失败"))
+	}
+
+	problems := validateConfigData(data)
+	return configResult(map[string]interface{}{
+		"valid":  len(problems) == 0,
+		"errors": problems,
+	}, nil)
+}
+
+// ConfigToJSON 将YAML配置转换为JSON
+//
+//export ConfigToJSON
+func ConfigToJSON(cConfigYAML *C.char) (ret *C.char) {
+	defer recoverString(&ret)
+
+	var data map[string]interface{}
+	if err := yaml.Unmarshal([]byte(C.GoString(cConfigYAML)), &data); err != nil {
+		return configResult(nil, wrapError(CodeConfigParse, err, "YAML解析失败"))
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	return configResult(data, nil)
+}
+
+// ConfigFromJSON 将JSON配置转换为YAML，YAML文本放在data字段中
+//
+//export ConfigFromJSON
+func ConfigFromJSON(cConfigJSON *C.char) (ret *C.char) {
+	defer recoverString(&ret)
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(C.GoString(cConfigJSON)), &data); err != nil {
+		return configResult(nil, wrapError(CodeInvalidArgument, err, "JSON解析失败"))
+	}
+
+	yamlData, err := yaml.Marshal(data)
+	if err != nil {
+		return configResult(nil, wrapError(CodeSerialize, err, "YAML序列化失败"))
+	}
+	return configResult(string(yamlData), nil)
+}
+
+// ConfigProfile 配置文件列表项
+type ConfigProfile struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+	Current  bool   `json:"current"`
+}
+
+// ConfigListProfiles 列出目录下可用的YAML配置文件
+//
+//export ConfigListProfiles
+func ConfigListProfiles(cDirPath *C.char) (ret *C.char) {
+	defer recoverString(&ret)
+
+	dirPath := C.GoString(cDirPath)
+	if dirPath == "" {
+		dirPath = "."
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return configResult(nil, wrapError(CodeConfigIO, err, "读取配置目录失败"))
+	}
+
+	currentPath, _ := filepath.Abs(GetConfig().currentPath())
+	profiles := make([]ConfigProfile, 0, len(entries))
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		profilePath := filepath.Join(dirPath, entry.Name())
+		absPath, _ := filepath.Abs(profilePath)
+		profiles = append(profiles, ConfigProfile{
+			Name:     strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+			Path:     profilePath,
+			Size:     info.Size(),
+			Modified: info.ModTime().Format(time.RFC3339),
+			Current:  absPath == currentPath,
+		})
+	}
+
+	return configResult(profiles, nil)
+}
+
+// ConfigHotReload 从磁盘重新读取当前配置文件，核心运行中时同步重载
+//
+//export ConfigHotReload
+func ConfigHotReload() (ret *C.char) {
+	defer recoverString(&ret)
+
+	configPath := GetConfig().currentPath()
+	if err := loadConfigFile(configPath); err != nil {
+		return configResult(nil, err)
+	}
+
+	report, err := reloadCore(GetConfig().currentPath())
+	if err != nil {
+		return configResult(map[string]interface{}{"reload": report}, err)
+	}
+
+	snapshot := currentConfigSnapshot()
+	snapshot["running"] = coreRunning()
+	snapshot["reload"] = report
+	return configResult(snapshot, nil)
+}
+
+// ConfigReload 重载指定配置文件（空字符串沿用当前配置），返回变化段落和回滚情况
+//
+//export ConfigReload
+func ConfigReload(cFilePath *C.char) (ret *C.char) {
+	defer recoverString(&ret)
+
+	report, err := reloadCore(C.GoString(cFilePath))
+	return configResult(report, err)
+}
+
+// currentPath 获取当前配置路径
+func (c *Config) currentPath() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Path
+}
+
+// currentConfigSnapshot 当前配置的JSON友好快照
+func currentConfigSnapshot() map[string]interface{} {
+	config := GetConfig()
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+
+	data := config.Data
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	return map[string]interface{}{
+		"path":   config.Path,
+		"config": data,
+	}
+}
+
+// validateConfigData 对配置做结构性检查，返回发现的问题列表
+func validateConfigData(data map[string]interface{}) []string {
+	problems := []string{}
+
+	// 各顶层段落的期望类型
+	listSections := []string{"proxies", "proxy-groups", "rules"}
+	mapSections := []string{"dns", "hosts", "tun", "experimental"}
+
+	for _, key := range listSections {
+		if value, ok := data[key]; ok {
+			if _, isList := value.([]interface{}); !isList {
+				problems = append(problems, fmt.Sprintf("%s 必须是列表", key))
+			}
+		}
+	}
+	for _, key := range mapSections {
+		if value, ok := data[key]; ok {
+			if _, isMap := value.(map[string]interface{}); !isMap {
+				problems = append(problems, fmt.Sprintf("%s 必须是对象", key))
+			}
+		}
+	}
+
+	// 端口范围检查
+	for _, key := range []string{"port", "socks-port", "mixed-port", "redir-port", "tproxy-port"} {
+		value, ok := data[key]
+		if !ok {
+			continue
+		}
+		port, isNumber := value.(float64)
+		if !isNumber || port < 0 || port > 65535 || port != float64(int(port)) {
+			problems = append(problems, fmt.Sprintf("%s 必须是0-65535之间的整数", key))
+		}
+	}
+
+	if mode, ok := data["mode"]; ok {
+		switch strings.ToLower(fmt.Sprint(mode)) {
+		case "rule", "global", "direct":
+		default:
+			problems = append(problems, fmt.Sprintf("未知的mode: %v", mode))
+		}
 	}
 
-	return string(jsonData)
+	return problems
 }
@@ -0,0 +1,203 @@
+// 内嵌Mihomo引擎
+// 负责解析配置文件并启动/停止真实的mihomo hub（入站监听、隧道、DNS）
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	mconfig "github.com/metacubex/mihomo/config"
+	mconst "github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/hub/executor"
+	mlog "github.com/metacubex/mihomo/log"
+	"github.com/metacubex/mihomo/tunnel/statistic"
+	"github.com/mihomo-flutter-cross/core/logger"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// engineProfile 一份已解析的引擎配置，保留原始内容用于重载时比较和回滚
+type engineProfile struct {
+	path     string
+	raw      []byte
+	sections map[string]interface{}
+	cfg      *mconfig.Config
+}
+
+// 引擎状态，由engineMu保护
+var (
+	engineMu      sync.Mutex
+	engineCurrent *engineProfile
+)
+
+// mihomo日志统一经由logger输出（module为engine），关闭其自带的logrus输出避免重复
+func init() {
+	logrus.SetOutput(io.Discard)
+
+	sub := mlog.Subscribe()
+	go func() {
+		for event := range sub {
+			engineLog.Log(logger.Level(event.LogLevel), "%s", event.Payload)
+		}
+	}()
+}
+
+// resolveEnginePath 将InitializeCore记录的路径转换为配置文件绝对路径
+func resolveEnginePath(configPath string) (string, error) {
+	if configPath == "" || configPath == "default" {
+		configPath = defaultConfigPath
+	}
+
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return "", wrapError(CodeInvalidArgument, err, "无效的配置路径: %s", configPath)
+	}
+	if _, err := os.Stat(absPath); err != nil {
+		return "", wrapError(CodeConfigIO, err, "配置文件不可用: %s", absPath)
+	}
+	return absPath, nil
+}
+
+// setEngineHome 配置文件所在目录作为mihomo的工作目录（geo数据、规则集、缓存文件等）
+// 需在解析配置之前设置，解析时按工作目录解析相对路径
+func setEngineHome(absPath string) {
+	mconst.SetHomeDir(filepath.Dir(absPath))
+	mconst.SetConfig(absPath)
+}
+
+// parseEngineConfig 读取并解析mihomo配置
+func parseEngineConfig(absPath string) (*engineProfile, error) {
+	raw, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, wrapError(CodeConfigIO, err, "读取配置文件失败: %s", absPath)
+	}
+	return parseEngineBytes(absPath, raw)
+}
+
+// parseEngineBytes 解析配置内容，同时保留顶层段落用于差异比较
+func parseEngineBytes(absPath string, raw []byte) (*engineProfile, error) {
+	var sections map[string]interface{}
+	if err := yaml.Unmarshal(raw, &sections); err != nil {
+		return nil, wrapError(CodeConfigParse, err, "YAML解析失败: %s", absPath)
+	}
+	if sections == nil {
+		sections = make(map[string]interface{})
+	}
+
+	rawCfg, err := mconfig.UnmarshalRawConfig(raw)
+	if err != nil {
+		return nil, wrapError(CodeConfigParse, err, "mihomo配置解析失败: %s", absPath)
+	}
+	if err := applyCacheFile(rawCfg, sections, absPath); err != nil {
+		return nil, err
+	}
+
+	cfg, err := mconfig.ParseRawConfig(rawCfg)
+	if err != nil {
+		return nil, wrapError(CodeConfigParse, err, "mihomo配置解析失败: %s", absPath)
+	}
+
+	return &engineProfile{
+		path:     absPath,
+		raw:      raw,
+		sections: sections,
+		cfg:      cfg,
+	}, nil
+}
+
+// startEngine 解析配置并启动mihomo hub
+func startEngine(configPath string) error {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+
+	if engineCurrent != nil {
+		return newError(CodeRunning, "mihomo引擎已在运行")
+	}
+
+	absPath, err := resolveEnginePath(configPath)
+	if err != nil {
+		return err
+	}
+
+	setEngineHome(absPath)
+
+	profile, err := parseEngineConfig(absPath)
+	if err != nil {
+		return err
+	}
+
+	if err := applyEngineConfig(profile.cfg, true); err != nil {
+		return err
+	}
+	engineCurrent = profile
+	return nil
+}
+
+// applyEngineConfig 应用配置到mihomo，把内部panic转换为错误以便状态机进入failed
+func applyEngineConfig(cfg *mconfig.Config, force bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newError(CodeEngineStart, "mihomo应用配置失败: %v", r)
+		}
+	}()
+
+	prepareTunRoutingMark(cfg)
+	executor.ApplyConfig(cfg, force)
+	syncTunRoutingMark()
+	return nil
+}
+
+// stopEngine 关闭所有监听器和活动连接
+func stopEngine() (err error) {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+
+	if engineCurrent == nil {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = newError(CodeError, "mihomo关闭失败: %v", r)
+		}
+	}()
+
+	executor.Shutdown()
+	statistic.DefaultManager.Range(func(c statistic.Tracker) bool {
+		_ = c.Close()
+		return true
+	})
+
+	engineCurrent = nil
+	return nil
+}
+
+// EngineTraffic 引擎层面的流量统计
+type EngineTraffic struct {
+	UploadTotal   int64 `json:"uploadTotal"`
+	DownloadTotal int64 `json:"downloadTotal"`
+	UploadSpeed   int64 `json:"uploadSpeed"`
+	DownloadSpeed int64 `json:"downloadSpeed"`
+	Connections   int   `json:"connections"`
+}
+
+// engineTraffic 获取mihomo统计管理器中的流量快照
+func engineTraffic() EngineTraffic {
+	snapshot := statistic.DefaultManager.Snapshot()
+	up, down := statistic.DefaultManager.Now()
+	return EngineTraffic{
+		UploadTotal:   snapshot.UploadTotal,
+		DownloadTotal: snapshot.DownloadTotal,
+		UploadSpeed:   up,
+		DownloadSpeed: down,
+		Connections:   len(snapshot.Connections),
+	}
+}
+
+// engineVersion mihomo内核版本
+func engineVersion() string {
+	return mconst.Version
+}
@@ -0,0 +1,206 @@
+// 错误处理
+// 导出函数失败时按调用线程记录结构化错误，供宿主通过GetLastError查询
+
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 错误码，0-2与bridge.h中的MIHOOMO_SUCCESS/MIHOOMO_ERROR/MIHOOMO_RUNNING一致
+// 新增错误码只能追加，不能修改已有数值
+const (
+	CodeSuccess         int32 = 0  // 成功
+	CodeError           int32 = 1  // 通用错误
+	CodeRunning         int32 = 2  // 已在运行
+	CodeNotRunning      int32 = 3  // 未在运行
+	CodeInvalidArgument int32 = 4  // 参数错误
+	CodeNotInitialized  int32 = 5  // 核心未初始化
+	CodeConfigIO        int32 = 6  // 配置文件读写失败
+	CodeConfigParse     int32 = 7  // 配置解析失败
+	CodeConfigInvalid   int32 = 8  // 配置内容不合法
+	CodeSerialize       int32 = 9  // JSON/YAML序列化失败
+	CodeTunNotCreated   int32 = 10 // TUN接口未创建
+	CodeTunActive       int32 = 11 // TUN接口已存在
+	CodeInternalPanic   int32 = 12 // Go侧发生panic，已被导出函数入口捕获
+	CodeInvalidState    int32 = 13 // 当前状态不允许该操作
+	CodeBusy            int32 = 14 // 核心正在启动/停止/重载
+	CodeEngineStart     int32 = 15 // mihomo引擎启动失败
+	CodeReloadFailed    int32 = 16 // 配置重载应用失败（已回滚或回滚失败）
+	CodeLogIO           int32 = 17 // 日志文件读写失败
+	CodeTunPermission   int32 = 18 // 创建TUN设备权限不足（缺少CAP_NET_ADMIN）
+	CodeTunUnsupported  int32 = 19 // 当前平台不支持由核心创建TUN设备
+	CodeTunDevice       int32 = 20 // TUN设备创建、配置或读写失败
+	CodeCaptureIO       int32 = 21 // 抓包文件写入失败
+)
+
+// codeNames 错误码名称，与bridge.h中的宏名对应
+var codeNames = map[int32]string{
+	CodeSuccess:         "SUCCESS",
+	CodeError:           "ERROR",
+	CodeRunning:         "RUNNING",
+	CodeNotRunning:      "NOT_RUNNING",
+	CodeInvalidArgument: "INVALID_ARGUMENT",
+	CodeNotInitialized:  "NOT_INITIALIZED",
+	CodeConfigIO:        "CONFIG_IO",
+	CodeConfigParse:     "CONFIG_PARSE",
+	CodeConfigInvalid:   "CONFIG_INVALID",
+	CodeSerialize:       "SERIALIZE",
+	CodeTunNotCreated:   "TUN_NOT_CREATED",
+	CodeTunActive:       "TUN_ACTIVE",
+	CodeInternalPanic:   "INTERNAL_PANIC",
+	CodeInvalidState:    "INVALID_STATE",
+	CodeBusy:            "BUSY",
+	CodeEngineStart:     "ENGINE_START",
+	CodeReloadFailed:    "RELOAD_FAILED",
+	CodeLogIO:           "LOG_IO",
+	CodeTunPermission:   "TUN_PERMISSION",
+	CodeTunUnsupported:  "TUN_UNSUPPORTED",
+	CodeTunDevice:       "TUN_DEVICE",
+	CodeCaptureIO:       "CAPTURE_IO",
+}
+
+// codeName 获取错误码名称
+func codeName(code int32) string {
+	if name, ok := codeNames[code]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// BridgeError 结构化错误信息
+type BridgeError struct {
+	Code      int32  `json:"code"`
+	Name      string `json:"name"`
+	Message   string `json:"message"`
+	Function  string `json:"function"`
+	Stack     string `json:"stack,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (e *BridgeError) Error() string {
+	return e.Message
+}
+
+// newError 创建带错误码的错误，Function记录为创建错误的函数
+func newError(code int32, format string, args ...interface{}) *BridgeError {
+	return &BridgeError{
+		Code:      code,
+		Name:      codeName(code),
+		Message:   fmt.Sprintf(format, args...),
+		Function:  callerName(2),
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
+
+// wrapError 为底层错误附加错误码，已是BridgeError时保留原错误码
+func wrapError(code int32, err error, format string, args ...interface{}) *BridgeError {
+	var bridgeErr *BridgeError
+	if errors.As(err, &bridgeErr) {
+		return bridgeErr
+	}
+
+	e := newError(code, "%s: %v", fmt.Sprintf(format, args...), err)
+	e.Function = callerName(2)
+	return e
+}
+
+// callerName 获取调用栈上第skip层的函数名（去掉包路径）
+func callerName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
+	if !ok {
+		return "unknown"
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// 每个宿主线程最多保留一条错误，线程数超过上限时淘汰旧记录
+const maxErrorThreads = 256
+
+var (
+	errMu      sync.Mutex
+	lastErrors = make(map[uint64]*BridgeError)
+)
+
+// setLastError 记录错误到当前线程并输出日志（panic连同调用栈），返回对应错误码
+func setLastError(err error) int32 {
+	var bridgeErr *BridgeError
+	if !errors.As(err, &bridgeErr) {
+		bridgeErr = newError(CodeError, "%v", err)
+		bridgeErr.Function = callerName(2)
+	}
+
+	threadID := currentThreadID()
+
+	errMu.Lock()
+	if _, exists := lastErrors[threadID]; !exists && len(lastErrors) >= maxErrorThreads {
+		for id := range lastErrors {
+			delete(lastErrors, id)
+			break
+		}
+	}
+	lastErrors[threadID] = bridgeErr
+	errMu.Unlock()
+
+	if bridgeErr.Stack != "" {
+		coreLog.Errorf("[%s] %s (%s)\n%s", bridgeErr.Function, bridgeErr.Message, bridgeErr.Name, bridgeErr.Stack)
+	} else {
+		coreLog.Errorf("[%s] %s (%s)", bridgeErr.Function, bridgeErr.Message, bridgeErr.Name)
+	}
+	return bridgeErr.Code
+}
+
+// failf 创建并记录错误，返回错误码，便于导出函数直接return
+func failf(code int32, format string, args ...interface{}) int32 {
+	e := newError(code, format, args...)
+	e.Function = callerName(2)
+	return setLastError(e)
+}
+
+// 获取当前线程最后的错误信息
+// 返回JSON: {"code":0,"name":"SUCCESS","message":"","function":"","timestamp":0}
+//
+//export GetLastError
+func GetLastError() (ret *C.char) {
+	defer recoverString(&ret)
+
+	errMu.Lock()
+	bridgeErr, exists := lastErrors[currentThreadID()]
+	errMu.Unlock()
+
+	if !exists {
+		bridgeErr = &BridgeError{Code: CodeSuccess, Name: codeName(CodeSuccess)}
+	}
+
+	data, err := json.Marshal(bridgeErr)
+	if err != nil {
+		return C.CString(`{"code":1,"name":"ERROR","message":"error serialization failed"}`)
+	}
+	return C.CString(string(data))
+}
+
+// 清除当前线程的错误信息
+//
+//export ClearError
+func ClearError() {
+	defer recoverVoid()
+
+	errMu.Lock()
+	defer errMu.Unlock()
+	delete(lastErrors, currentThreadID())
+}
@@ -0,0 +1,121 @@
+// Fake-IP解析
+// dns.enhanced-mode为fake-ip时，TUN客户端的查询由mihomo从dns.fake-ip-range分配地址应答，
+// 连接到达时隧道按目的地址反查域名再匹配规则。开启experimental.cache-file后映射写入缓存文件，
+// 核心重启后同一域名沿用原地址，重启前建立的长连接重连时仍能找回域名
+
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	mconfig "github.com/metacubex/mihomo/config"
+	mconst "github.com/metacubex/mihomo/constant"
+)
+
+// mihomo的缓存文件固定为工作目录（配置文件所在目录）下的cache.db
+const defaultCacheFile = "./cache.db"
+
+// cacheFileSettings 读取experimental.cache-file段落
+func cacheFileSettings(sections map[string]interface{}) (enable bool, path string) {
+	experimental, _ := sections["experimental"].(map[string]interface{})
+	cacheFile, _ := experimental["cache-file"].(map[string]interface{})
+	enable, _ = cacheFile["enable"].(bool)
+	path, _ = cacheFile["path"].(string)
+	if path == "" {
+		path = defaultCacheFile
+	}
+	return enable, path
+}
+
+// applyCacheFile 开启cache-file时让fake-ip池使用缓存文件
+// 缓存文件由mihomo在首次使用时按工作目录打开，只能是工作目录下的cache.db，配置其他路径时映射无法
+// 持久化，返回CodeConfigInvalid而不是静默只保留在内存中；配置中显式关闭profile.store-fake-ip时以它为准
+func applyCacheFile(rawCfg *mconfig.RawConfig, sections map[string]interface{}, absPath string) error {
+	enable, path := cacheFileSettings(sections)
+	if !enable {
+		return nil
+	}
+	profile, _ := sections["profile"].(map[string]interface{})
+	if _, explicit := profile["store-fake-ip"]; explicit && !rawCfg.Profile.StoreFakeIP {
+		configLog.Infof("profile.store-fake-ip为false，fake-ip映射不写入缓存文件")
+		return nil
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(absPath), path)
+	}
+	if filepath.Clean(path) != mconst.Path.Cache() {
+		return newError(CodeConfigInvalid, "缓存文件 %s 不在工作目录，fake-ip映射无法持久化，请使用 %s", path, mconst.Path.Cache())
+	}
+	rawCfg.Profile.StoreFakeIP = true
+	return nil
+}
+
+// FakeIPStatus GetFakeIPStatus返回的fake-ip状态
+type FakeIPStatus struct {
+	Enabled    bool   `json:"enabled"`
+	Range      string `json:"range,omitempty"`
+	Gateway    string `json:"gateway,omitempty"`
+	Persistent bool   `json:"persistent"`
+	CachePath  string `json:"cachePath,omitempty"`
+}
+
+// fakeIPStatus 当前运行配置的fake-ip状态，引擎未运行时enabled为false
+func fakeIPStatus() FakeIPStatus {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+
+	status := FakeIPStatus{}
+	if engineCurrent == nil {
+		return status
+	}
+	cfg := engineCurrent.cfg
+	if !cfg.DNS.Enable || cfg.DNS.EnhancedMode != mconst.DNSFakeIP || cfg.DNS.FakeIPRange == nil {
+		return status
+	}
+
+	status.Enabled = true
+	status.Range = cfg.DNS.FakeIPRange.IPNet().String()
+	status.Gateway = cfg.DNS.FakeIPRange.Gateway().String()
+	status.Persistent = cfg.Profile.StoreFakeIP
+	if status.Persistent {
+		status.CachePath = mconst.Path.Cache()
+	}
+	return status
+}
+
+// 获取fake-ip状态
+// 返回JSON {"enabled","range","gateway","persistent","cachePath"}，需要调用者释放内存
+//
+//export GetFakeIPStatus
+func GetFakeIPStatus() (ret *C.char) {
+	defer recoverString(&ret)
+
+	data, err := json.Marshal(fakeIPStatus())
+	if err != nil {
+		setLastError(wrapError(CodeSerialize, err, "fake-ip状态序列化失败"))
+		return C.CString("{}")
+	}
+	return C.CString(string(data))
+}
+
+// 清空fake-ip映射（包括缓存文件中的记录），之后的查询从地址池开头重新分配
+// 已按旧地址建立的连接不受影响，但新连接到旧地址时无法再反查域名
+//
+//export FlushFakeIPCache
+func FlushFakeIPCache() (ret int32) {
+	defer recoverCode(&ret)
+
+	if !fakeIPStatus().Enabled {
+		return failf(CodeNotRunning, "fake-ip未启用")
+	}
+	if err := resolver.FlushFakeIP(); err != nil {
+		return setLastError(wrapError(CodeError, err, "清空fake-ip映射失败"))
+	}
+
+	dnsLog.Infof("fake-ip映射已清空")
+	return CodeSuccess
+}
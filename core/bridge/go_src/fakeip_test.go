@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	mconfig "github.com/metacubex/mihomo/config"
+	mconst "github.com/metacubex/mihomo/constant"
+	"gopkg.in/yaml.v3"
+)
+
+func TestApplyCacheFile(t *testing.T) {
+	home := t.TempDir()
+	previous := mconst.Path.HomeDir()
+	mconst.SetHomeDir(home)
+	t.Cleanup(func() { mconst.SetHomeDir(previous) })
+	absPath := filepath.Join(home, "config.yaml")
+
+	tests := []struct {
+		name    string
+		config  string
+		store   bool
+		invalid bool
+	}{
+		{name: "未开启", config: "experimental:\n  cache-file:\n    enable: false\n", store: false},
+		{name: "默认路径", config: "experimental:\n  cache-file:\n    enable: true\n", store: true},
+		{name: "工作目录下的cache.db", config: "experimental:\n  cache-file:\n    enable: true\n    path: " + filepath.Join(home, "cache.db") + "\n", store: true},
+		{name: "其他路径", config: "experimental:\n  cache-file:\n    enable: true\n    path: ./fakeip.db\n", invalid: true},
+		{name: "显式开启store-fake-ip且路径不一致", config: "profile:\n  store-fake-ip: true\nexperimental:\n  cache-file:\n    enable: true\n    path: ./fakeip.db\n", invalid: true},
+		{name: "显式关闭store-fake-ip", config: "profile:\n  store-fake-ip: false\nexperimental:\n  cache-file:\n    enable: true\n    path: ./fakeip.db\n", store: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sections map[string]interface{}
+			if err := yaml.Unmarshal([]byte(tt.config), &sections); err != nil {
+				t.Fatal(err)
+			}
+			rawCfg, err := mconfig.UnmarshalRawConfig([]byte(tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = applyCacheFile(rawCfg, sections, absPath)
+			if tt.invalid {
+				var bridgeErr *BridgeError
+				if !errors.As(err, &bridgeErr) || bridgeErr.Code != CodeConfigInvalid {
+					t.Fatalf("applyCacheFile = %v，期望 %s", err, codeName(CodeConfigInvalid))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyCacheFile: %v", err)
+			}
+			if rawCfg.Profile.StoreFakeIP != tt.store {
+				t.Fatalf("store-fake-ip = %v，期望 %v", rawCfg.Profile.StoreFakeIP, tt.store)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Panic保护
+// 所有导出函数入口都通过defer调用这里的函数，把panic转换为错误码，避免Go panic导致宿主App崩溃
+
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// recoverCode 用于返回错误码的导出函数: defer recoverCode(&ret)
+func recoverCode(ret *int32) {
+	if r := recover(); r != nil {
+		*ret = handlePanic(r)
+	}
+}
+
+// recoverString 用于返回C字符串的导出函数: defer recoverString(&ret)
+// panic时返回 {"success":false,"code":12,"error":"..."}
+func recoverString(ret **C.char) {
+	if r := recover(); r != nil {
+		code := handlePanic(r)
+		data, _ := json.Marshal(ConfigResult{
+			Error: fmt.Sprintf("internal panic: %v", r),
+			Code:  code,
+		})
+		*ret = C.CString(string(data))
+	}
+}
+
+// recoverVoid 用于无返回值的导出函数: defer recoverVoid()
+func recoverVoid() {
+	if r := recover(); r != nil {
+		handlePanic(r)
+	}
+}
+
+// handlePanic 记录panic信息和调用栈，返回CodeInternalPanic；日志由setLastError连同调用栈输出一次
+func handlePanic(r interface{}) int32 {
+	stack := string(debug.Stack())
+	bridgeErr := &BridgeError{
+		Code:      CodeInternalPanic,
+		Name:      codeName(CodeInternalPanic),
+		Message:   fmt.Sprintf("internal panic: %v", r),
+		Function:  panicOrigin(),
+		Stack:     stack,
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	return setLastError(bridgeErr)
+}
+
+// panicOrigin 定位触发panic的函数（调用栈中最后一个runtime.gopanic之后的第一个非runtime帧）
+// defer中重新panic时栈上会有多个gopanic，最深的一个才是原始位置
+func panicOrigin() string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	origin := "unknown"
+	afterPanic := false
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" {
+			afterPanic = true
+		} else if afterPanic && !strings.HasPrefix(frame.Function, "runtime.") {
+			origin = frame.Function
+			if i := strings.LastIndex(origin, "."); i >= 0 {
+				origin = origin[i+1:]
+			}
+			afterPanic = false
+		}
+		if !more {
+			return origin
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// 宿主日志回调调用
+// 该文件不能包含//export，否则preamble中的函数定义会重复生成
+
+package main
+
+/*
+#include <stdint.h>
+
+typedef void (*MihomoLogCallback)(int32_t level, int64_t timestamp, char* module, char* message);
+
+static inline void mihomo_invoke_log_callback(MihomoLogCallback cb, int32_t level, int64_t timestamp, char* module, char* message) {
+	cb(level, timestamp, module, message);
+}
+
+// 当前线程是否正在调用宿主日志接收者
+static __thread int mihomo_log_delivering;
+
+static inline void mihomo_set_log_delivering(int delivering) {
+	mihomo_log_delivering = delivering;
+}
+
+static inline int mihomo_get_log_delivering(void) {
+	return mihomo_log_delivering;
+}
+*/
+import "C"
+
+import "github.com/mihomo-flutter-cross/core/logger"
+
+// invokeLogCallback 调用C日志回调，字符串所有权转移给宿主
+func invokeLogCallback(callback C.MihomoLogCallback, record logger.Record) {
+	C.mihomo_invoke_log_callback(
+		callback,
+		C.int32_t(record.Level),
+		C.int64_t(record.Timestamp),
+		C.CString(record.Module),
+		C.CString(record.Message),
+	)
+}
+
+// setLogDelivering 标记当前线程正在调用宿主日志接收者，日志线程已锁定到系统线程
+func setLogDelivering(delivering bool) {
+	value := C.int(0)
+	if delivering {
+		value = 1
+	}
+	C.mihomo_set_log_delivering(value)
+}
+
+// inLogDelivery 当前线程是否在宿主日志接收者内，即宿主在回调中再调用了核心接口
+func inLogDelivery() bool {
+	return C.mihomo_get_log_delivering() != 0
+}
@@ -0,0 +1,241 @@
+// 日志文件 - 桌面端持久化日志
+// SetLogDirectory开启文件日志，按大小/时间轮转并压缩旧文件，ExportLogBundle打包日志用于问题反馈
+
+package main
+
+import "C"
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mihomo-flutter-cross/core/logger"
+)
+
+// LogBundle 日志打包结果
+type LogBundle struct {
+	Path  string   `json:"path"`
+	Files []string `json:"files"`
+	Size  int64    `json:"size"`
+}
+
+var (
+	fileLogMu     sync.Mutex
+	fileLogSink   *logger.FileSink
+	fileLogRemove func()
+	fileLogOpts   = logger.FileOptions{
+		MaxSize:  logger.DefaultMaxSize,
+		MaxAge:   24 * time.Hour,
+		MaxFiles: logger.DefaultMaxFiles,
+		Compress: true,
+	}
+)
+
+// openFileLog 按当前配置重新打开文件日志，dir为空时关闭，调用者需持有fileLogMu
+func openFileLog(dir string) error {
+	var sink *logger.FileSink
+	if dir != "" {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return wrapError(CodeInvalidArgument, err, "无效的日志目录: %s", dir)
+		}
+		opts := fileLogOpts
+		opts.Dir = absDir
+		if sink, err = logger.NewFileSink(opts); err != nil {
+			return wrapError(CodeLogIO, err, "打开日志文件失败")
+		}
+	}
+
+	if fileLogRemove != nil {
+		fileLogRemove()
+		fileLogSink.Close()
+	}
+	fileLogSink, fileLogRemove = nil, nil
+
+	if sink != nil {
+		fileLogSink = sink
+		fileLogRemove = logger.AddSink(sink.Write)
+	}
+	return nil
+}
+
+// 设置日志目录，开启文件日志；空字符串关闭文件日志
+//
+//export SetLogDirectory
+func SetLogDirectory(cDir *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
+	dir := C.GoString(cDir)
+
+	fileLogMu.Lock()
+	err := openFileLog(dir)
+	fileLogMu.Unlock()
+	if err != nil {
+		return setLastError(err)
+	}
+
+	mu.Lock()
+	configMap["logdir"] = dir
+	mu.Unlock()
+
+	if dir == "" {
+		coreLog.Infof("文件日志已关闭")
+	} else {
+		coreLog.Infof("文件日志目录: %s", dir)
+	}
+	return CodeSuccess
+}
+
+// 设置日志文件轮转参数，文件日志已开启时立即生效
+//
+//export SetLogRotation
+func SetLogRotation(maxSizeMB, maxAgeHours, maxFiles, compress int32) (ret int32) {
+	defer recoverCode(&ret)
+
+	if maxSizeMB < 0 || maxAgeHours < 0 || maxFiles < 0 {
+		return failf(CodeInvalidArgument, "轮转参数不能为负数")
+	}
+
+	fileLogMu.Lock()
+	defer fileLogMu.Unlock()
+
+	fileLogOpts.MaxSize = int64(maxSizeMB) << 20
+	if maxSizeMB == 0 {
+		fileLogOpts.MaxSize = logger.DefaultMaxSize
+	}
+	fileLogOpts.MaxAge = time.Duration(maxAgeHours) * time.Hour
+	fileLogOpts.MaxFiles = int(maxFiles)
+	if maxFiles == 0 {
+		fileLogOpts.MaxFiles = logger.DefaultMaxFiles
+	}
+	fileLogOpts.Compress = compress != 0
+
+	if fileLogSink != nil {
+		if err := openFileLog(fileLogSink.Options().Dir); err != nil {
+			return setLastError(err)
+		}
+	}
+
+	coreLog.Infof("日志轮转: %d MB, %v, 保留 %d 个, 压缩: %v",
+		fileLogOpts.MaxSize>>20, fileLogOpts.MaxAge, fileLogOpts.MaxFiles, fileLogOpts.Compress)
+	return CodeSuccess
+}
+
+// 打包日志文件和当前状态为zip，用于问题反馈
+//
+//export ExportLogBundle
+func ExportLogBundle(cOutputPath *C.char) (ret *C.char) {
+	defer recoverString(&ret)
+
+	bundle, err := exportLogBundle(C.GoString(cOutputPath))
+	return configResult(bundle, err)
+}
+
+// exportLogBundle 打包全部日志文件，outputPath为空时写入日志目录
+func exportLogBundle(outputPath string) (*LogBundle, error) {
+	fileLogMu.Lock()
+	sink := fileLogSink
+	fileLogMu.Unlock()
+
+	if sink == nil {
+		return nil, newError(CodeInvalidState, "未开启文件日志，请先调用SetLogDirectory")
+	}
+	if outputPath == "" {
+		outputPath = filepath.Join(sink.Options().Dir, "mihomo-logs-"+time.Now().Format("20060102-150405")+".zip")
+	}
+
+	sink.Sync()
+	files, err := sink.Files()
+	if err != nil {
+		return nil, wrapError(CodeLogIO, err, "读取日志目录失败")
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return nil, wrapError(CodeLogIO, err, "创建日志包失败: %s", outputPath)
+	}
+
+	bundle := &LogBundle{Path: outputPath, Files: []string{}}
+	archive := zip.NewWriter(out)
+	err = writeLogBundle(archive, files, bundle)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return nil, wrapError(CodeLogIO, err, "写入日志包失败")
+	}
+
+	if info, err := os.Stat(outputPath); err == nil {
+		bundle.Size = info.Size()
+	}
+	coreLog.Infof("日志已打包: %s (%d 个文件)", outputPath, len(bundle.Files))
+	return bundle, nil
+}
+
+// writeLogBundle 写入状态快照和各日志文件
+func writeLogBundle(archive *zip.Writer, files []string, bundle *LogBundle) error {
+	status, err := json.MarshalIndent(coreStatus(), "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     "status.json",
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(status); err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		if err := addBundleFile(archive, path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		bundle.Files = append(bundle.Files, filepath.Base(path))
+	}
+	return nil
+}
+
+// addBundleFile 把单个文件写入zip，已压缩的.gz文件原样存储
+func addBundleFile(archive *zip.Writer, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Method = zip.Deflate
+	if filepath.Ext(path) == ".gz" {
+		header.Method = zip.Store
+	}
+
+	w, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
@@ -0,0 +1,305 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志文件名: 当前写入mihomo-core.log，轮转后为mihomo-core-<时间>.log[.gz]
+const (
+	filePrefix      = "mihomo-core"
+	fileExt         = ".log"
+	rotateTimeFmt   = "20060102T150405.000"
+	DefaultMaxSize  = 10 << 20
+	DefaultMaxFiles = 5
+)
+
+// FileOptions 文件日志配置
+type FileOptions struct {
+	Dir      string
+	MaxSize  int64         // 单个文件最大字节数，超过后轮转
+	MaxAge   time.Duration // 单个文件最长写入时间，0表示不按时间轮转
+	MaxFiles int           // 保留的轮转文件数，不含当前文件
+	Compress bool          // 轮转后gzip压缩
+}
+
+// FileSink 写入日志文件并按大小/时间轮转
+type FileSink struct {
+	opts FileOptions
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	startedAt time.Time // 当前文件第一条日志的时间，按时间轮转时以此计算时长
+
+	// compressMu 压缩和清理期间持有，Files/Close借此等待进行中的压缩
+	compressMu sync.Mutex
+}
+
+// NewFileSink 创建日志目录并打开当前日志文件
+func NewFileSink(opts FileOptions) (*FileSink, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("日志目录不能为空")
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = DefaultMaxFiles
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	sink := &FileSink{opts: opts}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Options 生效的配置
+func (f *FileSink) Options() FileOptions {
+	return f.opts
+}
+
+// activePath 当前日志文件路径
+func (f *FileSink) activePath() string {
+	return filepath.Join(f.opts.Dir, filePrefix+fileExt)
+}
+
+// open 以追加方式打开当前日志文件，调用者需持有mu（构造时除外）
+func (f *FileSink) open() error {
+	file, err := os.OpenFile(f.activePath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.startedAt = time.Now()
+	if f.size > 0 {
+		// 追加到已有文件时按文件中第一条日志计算时长，启动后很快退出的会话也能按时间轮转
+		f.startedAt = fileStartTime(f.activePath(), info.ModTime())
+	}
+	return nil
+}
+
+// fileStartTime 日志文件中第一条日志的时间（文本或JSON格式），无法识别时使用fallback
+func fileStartTime(path string, fallback time.Time) time.Time {
+	file, err := os.Open(path)
+	if err != nil {
+		return fallback
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReaderSize(file, 4096).ReadSlice('\n')
+	if err != nil && len(line) == 0 {
+		return fallback
+	}
+
+	var record struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+	if json.Unmarshal(line, &record) == nil && record.Timestamp > 0 {
+		return time.UnixMilli(record.Timestamp)
+	}
+	const textTimeFmt = "2006-01-02 15:04:05.000"
+	if len(line) >= len(textTimeFmt) {
+		if t, err := time.ParseInLocation(textTimeFmt, string(line[:len(textTimeFmt)]), time.Local); err == nil {
+			return t
+		}
+	}
+	return fallback
+}
+
+// Write 写入一条日志，可直接作为Sink注册
+func (f *FileSink) Write(record Record) {
+	line := formatLine(record)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return
+	}
+	if f.shouldRotate(int64(len(line))) {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "日志文件轮转失败: %v\n", err)
+			if f.file == nil {
+				return
+			}
+		}
+	}
+
+	n, _ := f.file.Write(line)
+	f.size += int64(n)
+}
+
+// shouldRotate 写入n字节前是否需要轮转
+func (f *FileSink) shouldRotate(n int64) bool {
+	if f.size > 0 && f.size+n > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.MaxAge > 0 && f.size > 0 && time.Since(f.startedAt) >= f.opts.MaxAge
+}
+
+// rotate 关闭当前文件并改名，重新打开新文件，调用者需持有mu
+func (f *FileSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	rotated := filepath.Join(f.opts.Dir, filePrefix+"-"+time.Now().Format(rotateTimeFmt)+fileExt)
+	if err := os.Rename(f.activePath(), rotated); err != nil {
+		f.open()
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	go func() {
+		f.compressMu.Lock()
+		defer f.compressMu.Unlock()
+
+		if f.opts.Compress {
+			if err := compressFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "日志文件压缩失败: %v\n", err)
+			}
+		}
+		f.prune()
+	}()
+	return nil
+}
+
+// compressFile gzip压缩文件并删除原文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	gz.Name = filepath.Base(path)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune 只保留最新的MaxFiles个轮转文件
+func (f *FileSink) prune() {
+	rotated, err := f.rotatedFiles()
+	if err != nil || len(rotated) <= f.opts.MaxFiles {
+		return
+	}
+	for _, path := range rotated[:len(rotated)-f.opts.MaxFiles] {
+		os.Remove(path)
+	}
+}
+
+// rotatedFiles 已轮转的日志文件，按时间从旧到新排序
+// 正在压缩的文件同时存在.log和.log.gz时只计.log
+func (f *FileSink) rotatedFiles() ([]string, error) {
+	entries, err := os.ReadDir(f.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var stamps []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix+"-") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), fileExt)
+		if stamp == name || seen[stamp] {
+			continue
+		}
+		seen[stamp] = true
+		stamps = append(stamps, stamp)
+	}
+	sort.Strings(stamps)
+
+	files := make([]string, 0, len(stamps))
+	for _, stamp := range stamps {
+		path := filepath.Join(f.opts.Dir, stamp+fileExt)
+		if _, err := os.Stat(path); err != nil {
+			path += ".gz"
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// Files 全部日志文件（轮转文件在前，当前文件在最后），会先等待进行中的压缩
+func (f *FileSink) Files() ([]string, error) {
+	f.compressMu.Lock()
+	defer f.compressMu.Unlock()
+
+	files, err := f.rotatedFiles()
+	if err != nil {
+		return nil, err
+	}
+	return append(files, f.activePath()), nil
+}
+
+// Sync 把当前文件刷到磁盘
+func (f *FileSink) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close 关闭当前文件并等待进行中的压缩
+func (f *FileSink) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+
+	f.compressMu.Lock()
+	f.compressMu.Unlock()
+	return err
+}
@@ -0,0 +1,287 @@
+// Package logger 桥接层统一日志
+// 支持debug/info/warn/error/silent级别、按模块标记、text/json两种输出格式
+// 级别和格式可在运行时调整，日志同时分发给已注册的接收者（如宿主回调）
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level 日志级别，数值与mihomo的log.LogLevel及bridge.h中的MIHOOMO_LOG_*一致
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+	Silent
+)
+
+var levelNames = map[Level]string{
+	Debug:  "debug",
+	Info:   "info",
+	Warn:   "warn",
+	Error:  "error",
+	Silent: "silent",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// MarshalText JSON中以字符串形式输出级别
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText 从字符串解析级别
+func (l *Level) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// ParseLevel 解析日志级别字符串，兼容warning写法
+func ParseLevel(level string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return Debug, nil
+	case "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "error":
+		return Error, nil
+	case "silent":
+		return Silent, nil
+	}
+	return Info, fmt.Errorf("未知的日志级别: %q", level)
+}
+
+// Format 输出格式
+type Format int32
+
+const (
+	FormatText Format = iota
+	FormatJSON
+)
+
+func (f Format) String() string {
+	if f == FormatJSON {
+		return "json"
+	}
+	return "text"
+}
+
+// ParseFormat 解析输出格式字符串
+func ParseFormat(format string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "text", "":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatText, fmt.Errorf("未知的日志格式: %q", format)
+}
+
+// 模块标记
+const (
+	ModuleCore   = "core"
+	ModuleTun    = "tun"
+	ModuleConfig = "config"
+	ModuleDNS    = "dns"
+	ModuleEngine = "engine" // mihomo内核自身的日志
+	ModuleHost   = "host"   // 宿主通过LogCallback写入的日志
+)
+
+// Record 一条结构化日志
+type Record struct {
+	Seq       uint64 `json:"seq"` // 递增序号，从1开始
+	Level     Level  `json:"level"`
+	Timestamp int64  `json:"timestamp"` // 毫秒
+	Module    string `json:"module"`
+	Message   string `json:"message"`
+}
+
+// Sink 日志接收者，按序号顺序同步调用，不能阻塞也不能再写日志
+type Sink func(Record)
+
+var (
+	level  atomic.Int32
+	format atomic.Int32
+
+	// dispatchMu 保证序号分配、输出和分发顺序一致
+	dispatchMu sync.Mutex
+	seq        uint64
+
+	outMu  sync.Mutex
+	output io.Writer = os.Stdout
+
+	sinkMu     sync.RWMutex
+	sinks      = make(map[int]Sink)
+	nextSinkID int
+)
+
+func init() {
+	level.Store(int32(Info))
+}
+
+// SetLevel 设置日志级别，低于该级别的日志被丢弃
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// GetLevel 当前日志级别
+func GetLevel() Level {
+	return Level(level.Load())
+}
+
+// Enabled 该级别的日志是否会输出
+func Enabled(l Level) bool {
+	return l >= GetLevel() && l < Silent
+}
+
+// SetFormat 设置输出格式
+func SetFormat(f Format) {
+	format.Store(int32(f))
+}
+
+// GetFormat 当前输出格式
+func GetFormat() Format {
+	return Format(format.Load())
+}
+
+// SetOutput 设置输出目标，nil表示不输出（只分发给接收者）
+func SetOutput(w io.Writer) {
+	outMu.Lock()
+	defer outMu.Unlock()
+
+	if w == nil {
+		w = io.Discard
+	}
+	output = w
+}
+
+// AddSink 注册日志接收者，返回注销函数
+func AddSink(sink Sink) func() {
+	sinkMu.Lock()
+	id := nextSinkID
+	nextSinkID++
+	sinks[id] = sink
+	sinkMu.Unlock()
+
+	return func() {
+		sinkMu.Lock()
+		delete(sinks, id)
+		sinkMu.Unlock()
+	}
+}
+
+// Log 按级别写一条日志
+func Log(l Level, module, msgFormat string, args ...interface{}) {
+	if !Enabled(l) {
+		return
+	}
+
+	message := msgFormat
+	if len(args) > 0 {
+		message = fmt.Sprintf(msgFormat, args...)
+	}
+
+	dispatchMu.Lock()
+	defer dispatchMu.Unlock()
+
+	seq++
+	record := Record{
+		Seq:       seq,
+		Level:     l,
+		Timestamp: time.Now().UnixMilli(),
+		Module:    module,
+		Message:   message,
+	}
+
+	write(record)
+
+	sinkMu.RLock()
+	defer sinkMu.RUnlock()
+	for _, sink := range sinks {
+		sink(record)
+	}
+}
+
+// write 按当前格式输出
+func write(record Record) {
+	line := formatLine(record)
+
+	outMu.Lock()
+	defer outMu.Unlock()
+	output.Write(line)
+}
+
+// formatLine 按当前格式生成一行日志
+func formatLine(record Record) []byte {
+	if GetFormat() == FormatJSON {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return []byte(formatText(record))
+		}
+		return append(data, '\n')
+	}
+	return []byte(formatText(record))
+}
+
+// formatText 文本格式: 时间 级别 [模块] 内容
+func formatText(record Record) string {
+	timestamp := time.UnixMilli(record.Timestamp).Format("2006-01-02 15:04:05.000")
+	return fmt.Sprintf("%s %-5s [%s] %s\n", timestamp, strings.ToUpper(record.Level.String()), record.Module, record.Message)
+}
+
+// Logger 绑定模块的日志器
+type Logger struct {
+	module string
+}
+
+// New 创建模块日志器
+func New(module string) *Logger {
+	return &Logger{module: module}
+}
+
+// Module 模块名
+func (l *Logger) Module() string {
+	return l.module
+}
+
+// Log 按指定级别写日志
+func (l *Logger) Log(level Level, format string, args ...interface{}) {
+	Log(level, l.module, format, args...)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	Log(Debug, l.module, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	Log(Info, l.module, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	Log(Warn, l.module, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	Log(Error, l.module, format, args...)
+}
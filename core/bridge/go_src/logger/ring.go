@@ -0,0 +1,71 @@
+package logger
+
+import "sync"
+
+// Ring 固定容量的最近日志缓冲，写满后覆盖最旧的记录
+type Ring struct {
+	mu      sync.RWMutex
+	records []Record
+	next    int
+	full    bool
+}
+
+// NewRing 创建容量为size的日志缓冲
+func NewRing(size int) *Ring {
+	if size <= 0 {
+		size = 1
+	}
+	return &Ring{records: make([]Record, size)}
+}
+
+// Add 追加一条日志，可直接作为Sink注册
+func (r *Ring) Add(record Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records[r.next] = record
+	r.next++
+	if r.next == len(r.records) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// RingPage 一次查询的结果
+type RingPage struct {
+	Records   []Record `json:"logs"`
+	OldestSeq uint64   `json:"oldestSeq"` // 缓冲中最旧记录的序号，0表示缓冲为空
+	LatestSeq uint64   `json:"latestSeq"` // 缓冲中最新记录的序号
+	HasMore   bool     `json:"hasMore"`   // 超出limit还有匹配的记录，用最后一条的seq继续查询
+}
+
+// Query 按时间顺序返回序号大于sinceSeq且级别不低于minLevel的日志，最多limit条
+func (r *Ring) Query(sinceSeq uint64, minLevel Level, limit int) RingPage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	page := RingPage{Records: []Record{}}
+
+	start, count := 0, r.next
+	if r.full {
+		start, count = r.next, len(r.records)
+	}
+	if count == 0 {
+		return page
+	}
+	page.OldestSeq = r.records[start].Seq
+	page.LatestSeq = r.records[(start+count-1)%len(r.records)].Seq
+
+	for i := 0; i < count; i++ {
+		record := r.records[(start+i)%len(r.records)]
+		if record.Seq <= sinceSeq || record.Level < minLevel {
+			continue
+		}
+		if limit > 0 && len(page.Records) >= limit {
+			page.HasMore = true
+			break
+		}
+		page.Records = append(page.Records, record)
+	}
+	return page
+}
@@ -0,0 +1,215 @@
+// 日志推送 - 核心向宿主推送结构化日志
+// 桌面端通过RegisterLogCallback注册C函数指针，移动端通过SetLogHandler注册gomobile接口
+// 日志记录先进入队列，由单独的goroutine投递，宿主回调不会阻塞核心
+
+package main
+
+/*
+#include <stdint.h>
+
+typedef void (*MihomoLogCallback)(int32_t level, int64_t timestamp, char* module, char* message);
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mihomo-flutter-cross/core/logger"
+)
+
+// 各模块日志器
+var (
+	coreLog   = logger.New(logger.ModuleCore)
+	configLog = logger.New(logger.ModuleConfig)
+	tunLog    = logger.New(logger.ModuleTun)
+	dnsLog    = logger.New(logger.ModuleDNS)
+	engineLog = logger.New(logger.ModuleEngine)
+	hostLog   = logger.New(logger.ModuleHost)
+)
+
+// LogHandler 移动端日志接收接口，gomobile会生成对应的Java/ObjC接口
+type LogHandler interface {
+	OnLog(level string, timestamp int64, module string, message string)
+}
+
+// 日志队列容量，宿主处理不过来时丢弃新日志
+const logQueueSize = 1024
+
+// 最近日志缓冲容量，以及GetRecentLogs未指定limit时的返回条数
+const (
+	recentLogSize  = 2000
+	recentLogLimit = 200
+)
+
+// recentLogs 最近的日志，供宿主晚于核心启动时回填
+var recentLogs = logger.NewRing(recentLogSize)
+
+var (
+	sinkMu       sync.RWMutex
+	hostCallback C.MihomoLogCallback
+	hostHandler  LogHandler
+
+	// 投递期间由日志线程持有，注销时等待进行中的投递结束
+	deliverMu sync.Mutex
+
+	logQueue     = make(chan logger.Record, logQueueSize)
+	logQueueOnce sync.Once
+	droppedLogs  atomic.Uint64
+)
+
+func init() {
+	logger.AddSink(recentLogs.Add)
+	logger.AddSink(pushToHost)
+}
+
+// hasLogSink 是否注册了宿主日志接收者
+func hasLogSink() bool {
+	sinkMu.RLock()
+	defer sinkMu.RUnlock()
+	return hostCallback != nil || hostHandler != nil
+}
+
+// pushToHost 日志接收者，把通过级别过滤的日志放入推送队列，未注册宿主接收者时直接丢弃
+func pushToHost(record logger.Record) {
+	if !hasLogSink() {
+		return
+	}
+
+	logQueueOnce.Do(func() { go dispatchLogs() })
+
+	select {
+	case logQueue <- record:
+	default:
+		droppedLogs.Add(1)
+	}
+}
+
+// dispatchLogs 投递日志到宿主
+// 接收者在sinkMu之外调用，宿主可以在回调中注册或注销；投递期间持有deliverMu，
+// 其他线程注销时会等待正在进行的回调结束。日志线程锁定到系统线程，用线程标记识别回调中的调用
+func dispatchLogs() {
+	runtime.LockOSThread()
+
+	for record := range logQueue {
+		deliverMu.Lock()
+		sinkMu.RLock()
+		callback, handler := hostCallback, hostHandler
+		sinkMu.RUnlock()
+
+		setLogDelivering(true)
+		if dropped := droppedLogs.Swap(0); dropped > 0 {
+			deliverLog(callback, handler, logger.Record{
+				Level:     logger.Warn,
+				Timestamp: record.Timestamp,
+				Module:    logger.ModuleCore,
+				Message:   fmt.Sprintf("日志队列已满，丢弃 %d 条日志", dropped),
+			})
+		}
+		deliverLog(callback, handler, record)
+		setLogDelivering(false)
+		deliverMu.Unlock()
+	}
+}
+
+// deliverLog 调用投递开始时注册的接收者
+// 回调中的panic只能输出到标准输出，再写日志会重新进入推送队列
+func deliverLog(callback C.MihomoLogCallback, handler LogHandler, record logger.Record) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("💥 日志回调异常: %v\n", r)
+		}
+	}()
+
+	if callback != nil {
+		invokeLogCallback(callback, record)
+	}
+	if handler != nil {
+		handler.OnLog(record.Level.String(), record.Timestamp, record.Module, record.Message)
+	}
+}
+
+// waitLogDelivery 等待正在进行的投递结束，在回调中调用时直接返回（当前回调即为进行中的投递）
+func waitLogDelivery() {
+	if inLogDelivery() {
+		return
+	}
+	deliverMu.Lock()
+	deliverMu.Unlock()
+}
+
+// 注册宿主日志回调
+// 回调在核心的日志线程上调用，module和message由Go分配，宿主处理完后需调用FreeString释放
+//
+//export RegisterLogCallback
+func RegisterLogCallback(callback C.MihomoLogCallback) (ret int32) {
+	defer recoverCode(&ret)
+
+	if callback == nil {
+		return failf(CodeInvalidArgument, "日志回调不能为空")
+	}
+
+	sinkMu.Lock()
+	hostCallback = callback
+	sinkMu.Unlock()
+
+	coreLog.Infof("已注册日志回调，级别: %s", logger.GetLevel())
+	return CodeSuccess
+}
+
+// 注销宿主日志回调，返回后不会再有回调发生；在回调中注销时，当前回调返回后不再有回调
+//
+//export UnregisterLogCallback
+func UnregisterLogCallback() (ret int32) {
+	defer recoverCode(&ret)
+
+	sinkMu.Lock()
+	hostCallback = nil
+	sinkMu.Unlock()
+	waitLogDelivery()
+
+	coreLog.Infof("已注销日志回调")
+	return CodeSuccess
+}
+
+// SetLogHandler 注册移动端日志接收者，传nil注销
+func SetLogHandler(handler LogHandler) {
+	sinkMu.Lock()
+	hostHandler = handler
+	sinkMu.Unlock()
+	waitLogDelivery()
+}
+
+// 查询最近的日志，按时间顺序返回序号大于sinceSeq的记录
+// 翻页时用上次结果最后一条的seq作为sinceSeq，hasMore为false表示已追上最新日志
+//
+//export GetRecentLogs
+func GetRecentLogs(sinceSeq int64, cLevel *C.char, limit int32) (ret *C.char) {
+	defer recoverString(&ret)
+
+	minLevel := logger.Debug
+	if levelName := C.GoString(cLevel); levelName != "" {
+		level, err := logger.ParseLevel(levelName)
+		if err != nil {
+			setLastError(wrapError(CodeInvalidArgument, err, "查询日志失败"))
+			return C.CString(`{"logs":[]}`)
+		}
+		minLevel = level
+	}
+	if sinceSeq < 0 {
+		sinceSeq = 0
+	}
+	if limit <= 0 {
+		limit = recentLogLimit
+	}
+
+	data, err := json.Marshal(recentLogs.Query(uint64(sinceSeq), minLevel, int(limit)))
+	if err != nil {
+		setLastError(wrapError(CodeSerialize, err, "日志序列化失败"))
+		return C.CString(`{"logs":[]}`)
+	}
+	return C.CString(string(data))
+}
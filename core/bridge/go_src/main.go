@@ -2,4 +2,4 @@ package main
 
 import "C"
 
-// 这个文件主要用于测试，所有核心功能都在 bridge.go 中实现
\ No newline at end of file
+// 这个文件主要用于测试，所有核心功能都在 bridge.go 中实现
@@ -0,0 +1,35 @@
+// 内存管理辅助函数
+// Go侧通过C.CString返回的字符串都分配在C堆上，需要调用者通过FreeString释放
+
+package main
+
+/*
+#include <stdlib.h>
+#include <string.h>
+*/
+import "C"
+
+import "unsafe"
+
+// 释放由Go函数返回的字符串内存
+//
+//export FreeString
+func FreeString(str *C.char) {
+	defer recoverVoid()
+
+	if str != nil {
+		C.free(unsafe.Pointer(str))
+	}
+}
+
+// 获取C字符串长度（字节数，不含结尾的\0）
+//
+//export GetStringLength
+func GetStringLength(str *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
+	if str == nil {
+		return 0
+	}
+	return int32(C.strlen(str))
+}
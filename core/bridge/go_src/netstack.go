@@ -0,0 +1,382 @@
+// TUN用户态协议栈
+// 从TUN读出的IP包注入gVisor netstack，由协议栈终结TCP/UDP连接后交给mihomo tunnel按规则转发
+// 协议栈发出的IP包（握手、应答数据等）写回TUN设备
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metacubex/gvisor/pkg/buffer"
+	"github.com/metacubex/gvisor/pkg/tcpip"
+	"github.com/metacubex/gvisor/pkg/tcpip/adapters/gonet"
+	"github.com/metacubex/gvisor/pkg/tcpip/header"
+	"github.com/metacubex/gvisor/pkg/tcpip/link/channel"
+	"github.com/metacubex/gvisor/pkg/tcpip/network/ipv4"
+	"github.com/metacubex/gvisor/pkg/tcpip/network/ipv6"
+	"github.com/metacubex/gvisor/pkg/tcpip/stack"
+	"github.com/metacubex/gvisor/pkg/tcpip/transport/icmp"
+	"github.com/metacubex/gvisor/pkg/tcpip/transport/tcp"
+	"github.com/metacubex/gvisor/pkg/tcpip/transport/udp"
+	"github.com/metacubex/gvisor/pkg/waiter"
+	"github.com/metacubex/mihomo/adapter/inbound"
+	C "github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/transport/socks5"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// 协议栈参数
+const (
+	netstackNIC            tcpip.NICID = 1
+	netstackQueueSize                  = 1024
+	netstackMaxInFlight                = 1024
+	netstackTCPBuffer                  = 20 * 1024
+	netstackKeepAliveIdle              = 60 * time.Second
+	netstackKeepAliveIntvl             = 30 * time.Second
+	netstackUDPTimeout                 = 60 * time.Second
+	netstackCloseTimeout               = 5 * time.Second
+)
+
+// tunStack 绑定到一个TUN设备的gVisor协议栈，负责一次TunStart到TunStop之间的全部数据包处理
+type tunStack struct {
+	device   tunDevice
+	endpoint *channel.Endpoint
+	stack    *stack.Stack
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// closed之后不再登记新的goroutine，保证Wait之后没有Add
+	mu     sync.Mutex
+	closed bool
+
+	reader    sync.WaitGroup // 设备读取循环
+	workers   sync.WaitGroup // 写回循环、速率采样和UDP流
+	conns     sync.WaitGroup // 交给tunnel的TCP连接
+	connCount int32
+}
+
+// newTunStack 创建协议栈并启动设备读取、写回循环和速率采样
+func newTunStack(device tunDevice) (*tunStack, error) {
+	s := &tunStack{
+		device:   device,
+		endpoint: channel.New(netstackQueueSize, uint32(device.MTU()), ""),
+		stack: stack.New(stack.Options{
+			NetworkProtocols: []stack.NetworkProtocolFactory{
+				ipv4.NewProtocol,
+				ipv6.NewProtocol,
+			},
+			TransportProtocols: []stack.TransportProtocolFactory{
+				tcp.NewProtocol,
+				udp.NewProtocol,
+				icmp.NewProtocol4,
+				icmp.NewProtocol6,
+			},
+		}),
+	}
+
+	if err := s.setup(); err != nil {
+		s.stack.Close()
+		return nil, err
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.reader.Add(1)
+	go s.readLoop()
+	s.workers.Add(2)
+	go s.writeLoop()
+	go func() {
+		defer s.workers.Done()
+		tunSampler.run(s.ctx)
+	}()
+	return s, nil
+}
+
+// setup 创建网卡并接管所有目的地址的TCP/UDP连接
+func (s *tunStack) setup() error {
+	if err := s.stack.CreateNIC(netstackNIC, s.endpoint); err != nil {
+		return newError(CodeTunDevice, "创建协议栈网卡失败: %s", err)
+	}
+	s.stack.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: netstackNIC},
+		{Destination: header.IPv6EmptySubnet, NIC: netstackNIC},
+	})
+
+	// 目的地址不是本机也要接收，回包以原目的地址作为源地址
+	if err := s.stack.SetPromiscuousMode(netstackNIC, true); err != nil {
+		return newError(CodeTunDevice, "设置协议栈混杂模式失败: %s", err)
+	}
+	if err := s.stack.SetSpoofing(netstackNIC, true); err != nil {
+		return newError(CodeTunDevice, "设置协议栈地址伪装失败: %s", err)
+	}
+
+	s.stack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpip.TCPReceiveBufferSizeRangeOption{
+		Min: 1, Default: netstackTCPBuffer, Max: netstackTCPBuffer,
+	})
+	s.stack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpip.TCPSendBufferSizeRangeOption{
+		Min: 1, Default: netstackTCPBuffer, Max: netstackTCPBuffer,
+	})
+	sack := tcpip.TCPSACKEnabled(true)
+	s.stack.SetTransportProtocolOption(tcp.ProtocolNumber, &sack)
+
+	s.stack.SetTransportProtocolHandler(tcp.ProtocolNumber,
+		tcp.NewForwarder(s.stack, 0, netstackMaxInFlight, s.handleTCP).HandlePacket)
+	s.stack.SetTransportProtocolHandler(udp.ProtocolNumber,
+		udp.NewForwarder(s.stack, s.handleUDP).HandlePacket)
+	return nil
+}
+
+// readLoop 从设备读取IP数据包交给协议栈，设备关闭或协议栈关闭后退出
+func (s *tunStack) readLoop() {
+	defer s.reader.Done()
+	tunLog.Debugf("TUN读取循环启动: %s", s.device.Name())
+
+	packet := make([]byte, s.device.MTU())
+	for s.ctx.Err() == nil {
+		n, err := s.device.Read(packet)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) && s.ctx.Err() == nil {
+				tunLog.Warnf("读取TUN数据包失败: %v", err)
+			}
+			break
+		}
+		if n == 0 {
+			continue
+		}
+
+		tunStats.Load().countIn(packet[:n])
+		captureTunPacket(packet[:n], true)
+
+		s.inject(packet[:n])
+	}
+
+	tunLog.Debugf("TUN读取循环结束: %s", s.device.Name())
+}
+
+// inject 把从TUN读出的IP包交给协议栈，数据在返回前已复制
+func (s *tunStack) inject(packet []byte) {
+	var protocol tcpip.NetworkProtocolNumber
+	switch header.IPVersion(packet) {
+	case header.IPv4Version:
+		protocol = header.IPv4ProtocolNumber
+	case header.IPv6Version:
+		protocol = header.IPv6ProtocolNumber
+	default:
+		tunLog.Debugf("丢弃非IP数据包: %d 字节", len(packet))
+		return
+	}
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(append([]byte(nil), packet...)),
+	})
+	s.endpoint.InjectInbound(protocol, pkt)
+	pkt.DecRef()
+}
+
+// writeLoop 把协议栈发出的IP包写回TUN设备，协议栈关闭后退出
+func (s *tunStack) writeLoop() {
+	defer s.workers.Done()
+
+	packet := make([]byte, 0, s.device.MTU())
+	for {
+		pkt := s.endpoint.ReadContext(s.ctx)
+		if pkt == nil {
+			return
+		}
+
+		packet = packet[:0]
+		for _, slice := range pkt.AsSlices() {
+			packet = append(packet, slice...)
+		}
+		pkt.DecRef()
+
+		n, err := s.device.Write(packet)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				tunLog.Warnf("写入TUN数据包失败: %v", err)
+			}
+			continue
+		}
+
+		tunStats.Load().countOut(packet[:n])
+		captureTunPacket(packet[:n], false)
+	}
+}
+
+// handleTCP 完成握手后把连接交给tunnel，连接关闭前不返回
+func (s *tunStack) handleTCP(r *tcp.ForwarderRequest) {
+	if !s.track(&s.conns) {
+		r.Complete(true)
+		return
+	}
+	atomic.AddInt32(&s.connCount, 1)
+	defer func() {
+		atomic.AddInt32(&s.connCount, -1)
+		s.conns.Done()
+	}()
+
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		tunLog.Debugf("TUN TCP连接建立失败 %s: %s", flowString(r.ID()), tcpErr)
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
+
+	ep.SocketOptions().SetKeepAlive(true)
+	idle := tcpip.KeepaliveIdleOption(netstackKeepAliveIdle)
+	ep.SetSockOpt(&idle)
+	interval := tcpip.KeepaliveIntervalOption(netstackKeepAliveIntvl)
+	ep.SetSockOpt(&interval)
+
+	// 协议栈一侧的本端地址即原始目的地址
+	conn := gonet.NewTCPConn(&wq, ep)
+	tunLog.Debugf("TUN TCP连接: %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
+
+	if shouldHijackDNS("tcp", conn.LocalAddr()) {
+		tunStats.Load().dnsHijacked.Add(1)
+		hijackDNSConn(s.ctx, conn)
+		return
+	}
+
+	additions := tunAppAdditions("tcp", conn.RemoteAddr(), conn.LocalAddr())
+	counted := &tunCountedConn{TCPConn: conn, dest: tunStats.Load().destinations.open(conn.LocalAddr())}
+	tunnel.Tunnel.HandleTCPConn(inbound.NewSocket(socks5.ParseAddrToSocksAddr(conn.LocalAddr()), counted, C.TUN, additions...))
+}
+
+// handleUDP 为新的UDP流创建端点，之后该流的数据包都由一个goroutine读取
+func (s *tunStack) handleUDP(r *udp.ForwarderRequest) {
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		tunLog.Debugf("TUN UDP端点创建失败 %s: %s", flowString(r.ID()), tcpErr)
+		return
+	}
+
+	conn := gonet.NewUDPConn(&wq, ep)
+	if !s.track(&s.workers) {
+		conn.Close()
+		return
+	}
+	go s.relayUDP(conn)
+}
+
+// relayUDP 把一个UDP流的数据包逐个交给tunnel，空闲超时或协议栈关闭时结束
+func (s *tunStack) relayUDP(conn *gonet.UDPConn) {
+	defer s.workers.Done()
+	defer conn.Close()
+
+	target := socks5.ParseAddrToSocksAddr(conn.LocalAddr())
+	source := conn.RemoteAddr()
+	hijack := shouldHijackDNS("udp", conn.LocalAddr())
+	var dest *tunDestination
+	var additions []inbound.Addition
+	if !hijack {
+		dest = tunStats.Load().destinations.open(conn.LocalAddr())
+		additions = tunAppAdditions("udp", source, conn.LocalAddr())
+	}
+	tunLog.Debugf("TUN UDP流: %s -> %s", source, conn.LocalAddr())
+
+	// 读取缓冲区在流内复用，交给tunnel的数据包只复制实际长度（tunnel异步处理）
+	buf := make([]byte, s.device.MTU())
+	for {
+		conn.SetReadDeadline(time.Now().Add(netstackUDPTimeout))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		// 劫持的DNS查询由核心应答，不经过tunnel
+		if hijack {
+			tunStats.Load().dnsHijacked.Add(1)
+			hijackDNSPacket(s.ctx, buf[:n], conn)
+			continue
+		}
+		dest.upload.Add(uint64(n))
+
+		packet := &tunUDPPacket{data: append([]byte(nil), buf[:n]...), conn: conn, source: source, dest: dest}
+		tunnel.Tunnel.HandleUDPPacket(inbound.NewPacket(target, packet, C.TUN, additions...))
+	}
+}
+
+// track 在协议栈关闭前登记一个goroutine，已关闭时返回false
+func (s *tunStack) track(wg *sync.WaitGroup) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	wg.Add(1)
+	return true
+}
+
+// Close 关闭协议栈并等待数据包goroutine全部退出，调用前需先关闭设备让读取循环返回
+// 经TUN建立的TCP连接随协议栈断开，最多等待connTimeout，返回届时仍未结束的连接数
+func (s *tunStack) Close(connTimeout time.Duration) int {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	// 先停止注入，再关闭协议栈
+	s.cancel()
+	s.reader.Wait()
+	s.endpoint.Close()
+	s.stack.Close()
+	s.workers.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(connTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return 0
+	case <-timer.C:
+		return int(atomic.LoadInt32(&s.connCount))
+	}
+}
+
+// tunUDPPacket 交给tunnel的UDP数据包，应答经同一个流写回
+type tunUDPPacket struct {
+	data   []byte
+	conn   *gonet.UDPConn
+	source net.Addr
+	dest   *tunDestination
+}
+
+func (p *tunUDPPacket) Data() []byte {
+	return p.data
+}
+
+// WriteBack 回写应答，端点已连接到来源地址，应答的源地址总是原始目的地址
+func (p *tunUDPPacket) WriteBack(b []byte, _ net.Addr) (int, error) {
+	n, err := p.conn.Write(b)
+	p.dest.download.Add(uint64(n))
+	return n, err
+}
+
+func (p *tunUDPPacket) Drop() {
+	p.data = nil
+}
+
+func (p *tunUDPPacket) LocalAddr() net.Addr {
+	return p.source
+}
+
+// flowString 格式化连接四元组用于日志
+func flowString(id stack.TransportEndpointID) string {
+	return net.JoinHostPort(id.RemoteAddress.String(), strconv.Itoa(int(id.RemotePort))) + " -> " +
+		net.JoinHostPort(id.LocalAddress.String(), strconv.Itoa(int(id.LocalPort)))
+}
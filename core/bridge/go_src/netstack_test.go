@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mihomo-flutter-cross/core/logger"
+)
+
+// waitEngineLog 等待mihomo输出包含全部片段的日志，sinceSeq之前的日志不计
+func waitEngineLog(t *testing.T, sinceSeq uint64, parts ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, record := range recentLogs.Query(sinceSeq, logger.Debug, 0).Records {
+			if record.Module != logger.ModuleEngine {
+				continue
+			}
+			matched := true
+			for _, part := range parts {
+				if !strings.Contains(record.Message, part) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("没有匹配 %q 的mihomo日志", parts)
+}
+
+// TestTunIPv6RuleMatch 双栈TUN上的IPv6连接按IP-CIDR6规则分流，IPv4连接不受影响
+func TestTunIPv6RuleMatch(t *testing.T) {
+	startTestCore(t,
+		"IP-CIDR6,fd00:77::/64,REJECT",
+		"IP-CIDR,10.77.0.0/16,REJECT",
+		"MATCH,REJECT",
+	)
+
+	tunMutex.Lock()
+	previous := currentTunConfig
+	currentTunConfig = tunConfig{MTU: defaultTunMTU, Addresses: []netip.Prefix{
+		netip.MustParsePrefix("198.18.0.1/30"),
+		netip.MustParsePrefix("fdfe:dcba:9876::1/126"),
+	}}
+	setTunDNSIPv6(currentTunConfig)
+	tunMutex.Unlock()
+	t.Cleanup(func() {
+		tunMutex.Lock()
+		currentTunConfig = previous
+		setTunDNSIPv6(previous)
+		tunMutex.Unlock()
+	})
+
+	if code := TunAttachPacketFlow(1500); code != CodeSuccess {
+		t.Fatalf("TunAttachPacketFlow = %s", codeName(code))
+	}
+	t.Cleanup(func() { TunStop() })
+	if code := TunStart(); code != CodeSuccess {
+		t.Fatalf("TunStart = %s", codeName(code))
+	}
+	queue, err := hostQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client4 := netip.MustParseAddr("198.18.0.2")
+	client6 := netip.MustParseAddr("fdfe:dcba:9876::2")
+	tests := []struct {
+		name    string
+		network string
+		src     netip.AddrPort
+		dst     netip.AddrPort
+		rule    string
+	}{
+		{name: "IPv6 TCP", network: "tcp", src: netip.AddrPortFrom(client6, 41000), dst: netip.MustParseAddrPort("[fd00:77::1]:443"), rule: "IPCIDR(fd00:77::/64)"},
+		{name: "IPv6 UDP", network: "udp", src: netip.AddrPortFrom(client6, 41001), dst: netip.MustParseAddrPort("[fd00:77::1]:9"), rule: "IPCIDR(fd00:77::/64)"},
+		{name: "IPv6 网段外", network: "tcp", src: netip.AddrPortFrom(client6, 41002), dst: netip.MustParseAddrPort("[fd00:78::1]:443"), rule: "Match"},
+		{name: "IPv4 TCP", network: "tcp", src: netip.AddrPortFrom(client4, 41003), dst: netip.MustParseAddrPort("10.77.0.1:443"), rule: "IPCIDR(10.77.0.0/16)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			since := recentLogs.Query(0, logger.Debug, 0).LatestSeq
+			if tt.network == "tcp" {
+				tcpHandshake(t, queue, tt.src, tt.dst)
+			} else if ok, err := queue.push(udpPacket(tt.src, tt.dst, []byte("ping"))); !ok || err != nil {
+				t.Fatalf("push = %v, %v", ok, err)
+			}
+			waitEngineLog(t, since, tt.src.String()+" --> "+tt.dst.String(), "match "+tt.rule+" using REJECT")
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// pcapng文件写入
+// 只实现抓取TUN数据包需要的块：节头(SHB)、接口描述(IDB)和增强包(EPB)，链路类型为原始IP
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// pcapng块类型和选项
+const (
+	pcapngBlockSHB = 0x0A0D0D0A
+	pcapngBlockIDB = 0x00000001
+	pcapngBlockEPB = 0x00000006
+
+	pcapngByteOrderMagic = 0x1A2B3C4D
+	pcapngLinkTypeRaw    = 101 // LINKTYPE_RAW，以IPv4/IPv6头开始
+	pcapngSnapLen        = 65535
+
+	pcapngOptEnd       = 0
+	pcapngOptUserAppl  = 4 // shb_userappl
+	pcapngOptIfName    = 2 // if_name
+	pcapngOptIfTsresol = 9 // if_tsresol
+	pcapngOptEPBFlags  = 2 // epb_flags
+
+	// epb_flags的方向位
+	pcapngFlagInbound  = 1
+	pcapngFlagOutbound = 2
+)
+
+// pcapngWriter 顺序写入pcapng，时间戳精度为微秒
+type pcapngWriter struct {
+	w       io.Writer
+	written int64
+}
+
+// newPcapngWriter 写入节头和一个接口描述块
+func newPcapngWriter(w io.Writer, application, ifName string) (*pcapngWriter, error) {
+	p := &pcapngWriter{w: w}
+
+	shb := make([]byte, 0, 64)
+	shb = binary.LittleEndian.AppendUint32(shb, pcapngByteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // 主版本
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // 次版本
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	shb = appendPcapngOption(shb, pcapngOptUserAppl, []byte(application))
+	shb = appendPcapngOption(shb, pcapngOptEnd, nil)
+	if err := p.writeBlock(pcapngBlockSHB, shb); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 0, 64)
+	idb = binary.LittleEndian.AppendUint16(idb, pcapngLinkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, pcapngSnapLen)
+	if ifName != "" {
+		idb = appendPcapngOption(idb, pcapngOptIfName, []byte(ifName))
+	}
+	idb = appendPcapngOption(idb, pcapngOptIfTsresol, []byte{6})
+	idb = appendPcapngOption(idb, pcapngOptEnd, nil)
+	if err := p.writeBlock(pcapngBlockIDB, idb); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// writePacket 写入一个增强包块，outbound表示由本机发往TUN的包
+func (p *pcapngWriter) writePacket(timestamp time.Time, packet []byte, outbound bool) error {
+	captured := packet
+	if len(captured) > pcapngSnapLen {
+		captured = captured[:pcapngSnapLen]
+	}
+
+	ts := uint64(timestamp.UnixMicro())
+	flags := uint32(pcapngFlagInbound)
+	if outbound {
+		flags = pcapngFlagOutbound
+	}
+
+	body := make([]byte, 0, 20+len(captured)+3+12+4)
+	body = binary.LittleEndian.AppendUint32(body, 0) // 接口ID
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(captured)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(packet)))
+	body = append(body, captured...)
+	body = appendPcapngPadding(body)
+	body = appendPcapngOption(body, pcapngOptEPBFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	body = appendPcapngOption(body, pcapngOptEnd, nil)
+	return p.writeBlock(pcapngBlockEPB, body)
+}
+
+// Written 已写入的字节数
+func (p *pcapngWriter) Written() int64 {
+	return p.written
+}
+
+// writeBlock 写入块头、块体和块尾长度，块体需已按4字节对齐
+func (p *pcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	block := make([]byte, 0, total)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, total)
+
+	n, err := p.w.Write(block)
+	p.written += int64(n)
+	return err
+}
+
+// appendPcapngOption 追加一个选项，值按4字节对齐
+func appendPcapngOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return appendPcapngPadding(b)
+}
+
+func appendPcapngPadding(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/metacubex/gopacket/layers"
+	"github.com/metacubex/gopacket/pcapgo"
+)
+
+// TestPcapngWriter 写出的文件能被pcapng读取器打开，包内容、时间戳和接口信息保持一致
+func TestPcapngWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := newPcapngWriter(&buf, "mihomo-core test", "utun9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := time.UnixMicro(1700000000123456)
+	packets := [][]byte{
+		{0x45, 0x00, 0x00, 0x14},                   // 长度按4字节对齐
+		{0x60, 0x00, 0x00, 0x00, 0x00, 0x00, 0x11}, // 需要填充
+		make([]byte, pcapngSnapLen+10),             // 超过snaplen时截断
+	}
+	for i, packet := range packets {
+		if err := writer.writePacket(timestamp, packet, i%2 == 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if writer.Written() != int64(buf.Len()) {
+		t.Fatalf("Written = %d，实际写入 %d 字节", writer.Written(), buf.Len())
+	}
+
+	// SHB/IDB/EPB块长度都是4的倍数，块头和块尾的长度一致
+	data := buf.Bytes()
+	for offset := 0; offset < len(data); {
+		total := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		if total%4 != 0 || offset+total > len(data) {
+			t.Fatalf("偏移%d的块长度无效: %d", offset, total)
+		}
+		if trailer := int(binary.LittleEndian.Uint32(data[offset+total-4:])); trailer != total {
+			t.Fatalf("偏移%d的块尾长度 %d 与块头 %d 不一致", offset, trailer, total)
+		}
+		offset += total
+	}
+
+	reader, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatalf("打开pcapng失败: %v", err)
+	}
+	if reader.LinkType() != layers.LinkTypeRaw {
+		t.Fatalf("链路类型 = %v，期望原始IP", reader.LinkType())
+	}
+	if app := reader.SectionInfo().Application; app != "mihomo-core test" {
+		t.Fatalf("应用名 = %q", app)
+	}
+	if iface, err := reader.Interface(0); err != nil || iface.Name != "utun9" {
+		t.Fatalf("接口 = %+v, %v", iface, err)
+	}
+
+	for i, packet := range packets {
+		got, info, err := reader.ReadPacketData()
+		if err != nil {
+			t.Fatalf("读取第%d个包失败: %v", i, err)
+		}
+		want := packet
+		if len(want) > pcapngSnapLen {
+			want = want[:pcapngSnapLen]
+		}
+		if !bytes.Equal(got, want) || info.Length != len(packet) {
+			t.Fatalf("第%d个包 = %d/%d 字节，期望 %d/%d", i, len(got), info.Length, len(want), len(packet))
+		}
+		if !info.Timestamp.Equal(timestamp) {
+			t.Fatalf("第%d个包时间戳 = %v，期望 %v", i, info.Timestamp, timestamp)
+		}
+	}
+	if _, _, err := reader.ReadPacketData(); err != io.EOF {
+		t.Fatalf("末尾 = %v，期望EOF", err)
+	}
+}
@@ -0,0 +1,378 @@
+// 配置热重载
+// 解析并验证新配置，按段落比较差异，只把变化的部分应用到运行中的引擎，失败时回滚
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter/inbound"
+	mconfig "github.com/metacubex/mihomo/config"
+	mconst "github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/constant/provider"
+	"github.com/metacubex/mihomo/listener"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// 参与差异比较的配置段落及其包含的顶层键
+// 不在任何段落中的键归入general，general变化时整体重新应用
+var reloadSections = map[string][]string{
+	"listeners": {
+		"port", "socks-port", "redir-port", "tproxy-port", "mixed-port",
+		"allow-lan", "bind-address", "authentication", "skip-auth-prefixes",
+		"lan-allowed-ips", "lan-disallowed-ips", "listeners",
+		"ss-config", "vmess-config", "tuic-server",
+	},
+	"dns":     {"dns", "hosts", "use-hosts", "use-system-hosts"},
+	"rules":   {"rules", "sub-rules", "rule-providers"},
+	"proxies": {"proxies", "proxy-groups", "proxy-providers"},
+}
+
+// 重载应用方式
+const (
+	reloadAppliedNone     = "none"     // 配置无变化
+	reloadAppliedDeferred = "deferred" // 核心未运行，下次启动生效
+	reloadAppliedPartial  = "partial"  // 只应用了变化的段落
+	reloadAppliedFull     = "full"     // 整体重新应用
+)
+
+// ReloadReport 重载结果报告
+type ReloadReport struct {
+	Path       string   `json:"path"`
+	Applied    string   `json:"applied"`
+	Changed    []string `json:"changed"`
+	Unchanged  []string `json:"unchanged"`
+	Warnings   []string `json:"warnings,omitempty"`
+	RolledBack bool     `json:"rolledBack"`
+	Error      string   `json:"error,omitempty"`
+	Timestamp  int64    `json:"timestamp"`
+	DurationMs int64    `json:"durationMs"`
+}
+
+var (
+	reloadMu         sync.RWMutex
+	lastReloadReport *ReloadReport
+)
+
+// recordReloadReport 保存最近一次重载报告
+func recordReloadReport(report *ReloadReport) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	lastReloadReport = report
+}
+
+// latestReloadReport 获取最近一次重载报告
+func latestReloadReport() *ReloadReport {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return lastReloadReport
+}
+
+// sectionOf 顶层键所属的段落
+func sectionOf(key string) string {
+	for section, keys := range reloadSections {
+		for _, k := range keys {
+			if k == key {
+				return section
+			}
+		}
+	}
+	return "general"
+}
+
+// diffSections 比较新旧配置，返回变化和未变化的段落
+func diffSections(oldSections, newSections map[string]interface{}) (changed, unchanged []string) {
+	changed, unchanged = []string{}, []string{}
+	changedSet := make(map[string]bool)
+	for key, value := range newSections {
+		if !reflect.DeepEqual(toJSONCompatible(oldSections[key]), toJSONCompatible(value)) {
+			changedSet[sectionOf(key)] = true
+		}
+	}
+	for key := range oldSections {
+		if _, exists := newSections[key]; !exists {
+			changedSet[sectionOf(key)] = true
+		}
+	}
+
+	for _, section := range []string{"general", "listeners", "dns", "rules", "proxies"} {
+		if changedSet[section] {
+			changed = append(changed, section)
+		} else {
+			unchanged = append(unchanged, section)
+		}
+	}
+	return changed, unchanged
+}
+
+// validateEngineProfile 在mihomo解析之外做额外的结构检查
+func validateEngineProfile(profile *engineProfile) error {
+	data := make(map[string]interface{}, len(profile.sections))
+	for key, value := range profile.sections {
+		data[key] = toJSONCompatible(value)
+	}
+
+	if problems := validateConfigData(data); len(problems) > 0 {
+		return newError(CodeConfigInvalid, "配置验证失败: %v", problems)
+	}
+	return nil
+}
+
+// toJSONCompatible 把YAML解析出的值转换为JSON解析的形式，与JSON输入的验证规则保持一致：
+// 整数统一为float64，map[interface{}]interface{}转换为以字符串为键的map，逐层处理嵌套的值
+func toJSONCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[key] = toJSONCompatible(item)
+		}
+		return converted
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = toJSONCompatible(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = toJSONCompatible(item)
+		}
+		return converted
+	default:
+		return v
+	}
+}
+
+// reloadEngine 重载运行中的引擎
+// 调用方需保证核心处于reloading状态
+func reloadEngine(configPath string) (*ReloadReport, error) {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+
+	started := time.Now()
+	report := &ReloadReport{
+		Path:      configPath,
+		Changed:   []string{},
+		Unchanged: []string{},
+		Timestamp: started.UnixMilli(),
+	}
+	defer func() {
+		report.DurationMs = time.Since(started).Milliseconds()
+		recordReloadReport(report)
+	}()
+
+	if engineCurrent == nil {
+		return report, newError(CodeNotRunning, "mihomo引擎未运行")
+	}
+
+	if configPath == "" {
+		configPath = engineCurrent.path
+	}
+	report.Path = configPath
+
+	absPath, err := resolveEnginePath(configPath)
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	report.Path = absPath
+
+	// 新配置可能在其他目录，工作目录随配置切换，失败时恢复为旧配置所在目录
+	previous := engineCurrent
+	setEngineHome(absPath)
+
+	profile, err := parseEngineConfig(absPath)
+	if err == nil {
+		err = validateEngineProfile(profile)
+	}
+	if err != nil {
+		setEngineHome(previous.path)
+		report.Error = err.Error()
+		return report, err
+	}
+
+	report.Changed, report.Unchanged = diffSections(previous.sections, profile.sections)
+	if len(report.Changed) == 0 {
+		report.Applied = reloadAppliedNone
+		engineCurrent = profile
+		return report, nil
+	}
+
+	report.Warnings, err = applyEngineChanges(profile.cfg, report)
+	if err == nil {
+		engineCurrent = profile
+		return report, nil
+	}
+
+	// 应用失败，使用旧配置的原始内容重新解析并整体应用
+	report.Error = err.Error()
+	configLog.Warnf("配置应用失败，回滚到 %s: %v", previous.path, err)
+	setEngineHome(previous.path)
+	rollback, rollbackErr := parseEngineBytes(previous.path, previous.raw)
+	if rollbackErr == nil {
+		rollbackErr = applyEngineConfig(rollback.cfg, true)
+	}
+	if rollbackErr != nil {
+		// 保留旧的engineCurrent，确保后续StopMihomoProxy仍会执行关闭
+		return report, newError(CodeReloadFailed, "配置应用失败且回滚失败: %v; 回滚错误: %v", err, rollbackErr)
+	}
+
+	engineCurrent = rollback
+	report.RolledBack = true
+	return report, wrapError(CodeReloadFailed, err, "配置应用失败，已回滚")
+}
+
+// applyEngineChanges 根据差异应用配置，返回警告信息
+func applyEngineChanges(cfg *mconfig.Config, report *ReloadReport) (warnings []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("应用配置时发生panic: %v", r)
+		}
+	}()
+
+	changed := make(map[string]bool, len(report.Changed))
+	for _, section := range report.Changed {
+		changed[section] = true
+	}
+
+	// general和dns没有独立的更新入口，交给mihomo整体应用
+	if changed["general"] || changed["dns"] {
+		report.Applied = reloadAppliedFull
+		if err := applyEngineConfig(cfg, changed["listeners"]); err != nil {
+			return nil, err
+		}
+		return nil, verifyListeners(cfg)
+	}
+
+	report.Applied = reloadAppliedPartial
+	if changed["proxies"] {
+		tunnel.UpdateProxies(cfg.Proxies, cfg.Providers)
+		for _, pv := range cfg.Providers {
+			warnings = append(warnings, initialProvider(pv)...)
+		}
+	}
+	if changed["rules"] {
+		tunnel.UpdateRules(cfg.Rules, cfg.SubRules, cfg.RuleProviders)
+		for _, pv := range cfg.RuleProviders {
+			warnings = append(warnings, initialProvider(pv)...)
+		}
+	}
+	if changed["listeners"] {
+		applyListeners(cfg)
+		if err := verifyListeners(cfg); err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+// initialProvider 初始化provider，失败只作为警告（与mihomo启动时的行为一致）
+func initialProvider(pv provider.Provider) []string {
+	if pv.VehicleType() == provider.Compatible {
+		return nil
+	}
+	if err := pv.Initial(); err != nil {
+		return []string{fmt.Sprintf("provider %s 初始化失败: %v", pv.Name(), err)}
+	}
+	return nil
+}
+
+// applyListeners 重建入站监听器
+func applyListeners(cfg *mconfig.Config) {
+	general := cfg.General
+	listener.PatchInboundListeners(cfg.Listeners, tunnel.Tunnel, true)
+
+	listener.SetAllowLan(general.AllowLan)
+	inbound.SetSkipAuthPrefixes(general.SkipAuthPrefixes)
+	inbound.SetAllowedIPs(general.LanAllowedIPs)
+	inbound.SetDisAllowedIPs(general.LanDisAllowedIPs)
+	listener.SetBindAddress(general.BindAddress)
+
+	listener.ReCreateHTTP(general.Port, tunnel.Tunnel)
+	listener.ReCreateSocks(general.SocksPort, tunnel.Tunnel)
+	listener.ReCreateRedir(general.RedirPort, tunnel.Tunnel)
+	listener.ReCreateTProxy(general.TProxyPort, tunnel.Tunnel)
+	listener.ReCreateMixed(general.MixedPort, tunnel.Tunnel)
+	listener.ReCreateShadowSocks(general.ShadowSocksConfig, tunnel.Tunnel)
+	listener.ReCreateVmess(general.VmessConfig, tunnel.Tunnel)
+	listener.ReCreateTuic(general.TuicServer, tunnel.Tunnel)
+}
+
+// verifyListeners mihomo重建监听器失败时只打日志，这里通过实际端口确认是否绑定成功
+func verifyListeners(cfg *mconfig.Config) error {
+	ports := listener.GetPorts()
+	expected := map[string][2]int{
+		"port":        {cfg.General.Port, ports.Port},
+		"socks-port":  {cfg.General.SocksPort, ports.SocksPort},
+		"redir-port":  {cfg.General.RedirPort, ports.RedirPort},
+		"tproxy-port": {cfg.General.TProxyPort, ports.TProxyPort},
+		"mixed-port":  {cfg.General.MixedPort, ports.MixedPort},
+	}
+
+	var failed []string
+	for name, pair := range expected {
+		if pair[0] != 0 && pair[0] != pair[1] {
+			failed = append(failed, fmt.Sprintf("%s=%d", name, pair[0]))
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("监听端口绑定失败: %v", failed)
+	}
+	return nil
+}
+
+// validateReloadTarget 核心未运行时只验证配置，不应用
+func validateReloadTarget(configPath string) (*ReloadReport, error) {
+	started := time.Now()
+	report := &ReloadReport{
+		Path:      configPath,
+		Applied:   reloadAppliedDeferred,
+		Changed:   []string{},
+		Unchanged: []string{},
+		Timestamp: started.UnixMilli(),
+	}
+	defer func() {
+		report.DurationMs = time.Since(started).Milliseconds()
+		recordReloadReport(report)
+	}()
+
+	absPath, err := resolveEnginePath(configPath)
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	report.Path = absPath
+
+	// 与reloadEngine一样按新配置所在目录解析相对路径，验证后恢复原工作目录，启动时再切换
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	previousHome, previousConfig := mconst.Path.HomeDir(), mconst.Path.Config()
+	setEngineHome(absPath)
+	defer func() {
+		mconst.SetHomeDir(previousHome)
+		mconst.SetConfig(previousConfig)
+	}()
+
+	profile, err := parseEngineConfig(absPath)
+	if err == nil {
+		err = validateEngineProfile(profile)
+	}
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	return report, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	mconst "github.com/metacubex/mihomo/constant"
+)
+
+// TestValidateReloadTargetHome 未运行时验证配置也按新配置所在目录解析相对路径，验证后恢复工作目录
+func TestValidateReloadTargetHome(t *testing.T) {
+	previous := t.TempDir()
+	previousHome := mconst.Path.HomeDir()
+	mconst.SetHomeDir(previous)
+	t.Cleanup(func() { mconst.SetHomeDir(previousHome) })
+
+	// 规则集的下载路径必须在工作目录下，只有工作目录切换到新配置所在目录时才能通过验证
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	config := "mode: rule\nlog-level: silent\nrule-providers:\n  local:\n    type: http\n    behavior: domain\n" +
+		"    url: http://127.0.0.1:1/local.yaml\n    path: " + filepath.Join(dir, "rules", "local.yaml") + "\n"
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := validateReloadTarget(path); err != nil {
+		t.Fatalf("validateReloadTarget: %v", err)
+	}
+	if home := mconst.Path.HomeDir(); home != previous {
+		t.Fatalf("工作目录 = %s，期望恢复为 %s", home, previous)
+	}
+}
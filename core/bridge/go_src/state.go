@@ -0,0 +1,188 @@
+// 核心生命周期状态机
+// uninitialized → initialized → starting → running → stopping → stopped/failed
+// 所有生命周期相关的导出函数都通过这里检查和切换状态
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CoreState 核心状态
+type CoreState int32
+
+const (
+	StateUninitialized CoreState = iota
+	StateInitialized
+	StateStarting
+	StateRunning
+	StateReloading
+	StateStopping
+	StateStopped
+	StateFailed
+)
+
+var stateNames = map[CoreState]string{
+	StateUninitialized: "uninitialized",
+	StateInitialized:   "initialized",
+	StateStarting:      "starting",
+	StateRunning:       "running",
+	StateReloading:     "reloading",
+	StateStopping:      "stopping",
+	StateStopped:       "stopped",
+	StateFailed:        "failed",
+}
+
+func (s CoreState) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// allowedTransitions 合法的状态迁移表
+var allowedTransitions = map[CoreState][]CoreState{
+	StateUninitialized: {StateInitialized},
+	StateInitialized:   {StateInitialized, StateStarting},
+	StateStarting:      {StateRunning, StateFailed},
+	StateRunning:       {StateReloading, StateStopping},
+	StateReloading:     {StateRunning, StateFailed},
+	StateStopping:      {StateStopped, StateFailed},
+	StateStopped:       {StateInitialized, StateStarting},
+	StateFailed:        {StateInitialized, StateStarting, StateStopping},
+}
+
+// StateTransition 一次状态迁移记录
+type StateTransition struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// 保留的迁移历史条数
+const maxStateHistory = 16
+
+// coreLifecycle 核心状态机
+type coreLifecycle struct {
+	mu      sync.RWMutex
+	state   CoreState
+	reason  string
+	since   time.Time
+	history []StateTransition
+}
+
+var lifecycle = &coreLifecycle{
+	state:  StateUninitialized,
+	reason: "进程启动",
+	since:  time.Now(),
+}
+
+// transitionError 非法迁移对应的错误码
+func transitionError(from, to CoreState) *BridgeError {
+	switch {
+	case from == StateStarting || from == StateStopping || from == StateReloading:
+		return newError(CodeBusy, "核心正在%s，无法切换到%s", from, to)
+	case to == StateStarting && from == StateRunning:
+		return newError(CodeRunning, "代理已经在运行中")
+	case to == StateStarting && from == StateUninitialized:
+		return newError(CodeNotInitialized, "核心未初始化，请先调用InitializeCore")
+	case to == StateStopping || to == StateReloading:
+		return newError(CodeNotRunning, "代理未在运行 (当前状态: %s)", from)
+	case to == StateInitialized && from == StateRunning:
+		return newError(CodeRunning, "代理运行中，无法重新初始化")
+	default:
+		return newError(CodeInvalidState, "非法的状态迁移: %s → %s", from, to)
+	}
+}
+
+// transition 切换到目标状态，非法迁移时返回带错误码的错误
+func (l *coreLifecycle) transition(to CoreState, reason string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	from := l.state
+	allowed := false
+	for _, next := range allowedTransitions[from] {
+		if next == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		err := transitionError(from, to)
+		err.Function = callerName(2)
+		return err
+	}
+
+	now := time.Now()
+	l.state = to
+	l.reason = reason
+	l.since = now
+
+	l.history = append(l.history, StateTransition{
+		From:      from.String(),
+		To:        to.String(),
+		Reason:    reason,
+		Timestamp: now.UnixMilli(),
+	})
+	if len(l.history) > maxStateHistory {
+		l.history = l.history[len(l.history)-maxStateHistory:]
+	}
+
+	coreLog.Infof("核心状态: %s → %s (%s)", from, to, reason)
+	return nil
+}
+
+// current 获取当前状态
+func (l *coreLifecycle) current() CoreState {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.state
+}
+
+// CoreStatus GetMihomoStatus返回的状态信息
+type CoreStatus struct {
+	Status      string            `json:"status"`
+	Reason      string            `json:"reason"`
+	Since       int64             `json:"since"`
+	Config      string            `json:"config"`
+	Version     string            `json:"version"`
+	Engine      string            `json:"engine"`
+	Transitions []StateTransition `json:"transitions"`
+	LastReload  *ReloadReport     `json:"lastReload,omitempty"`
+}
+
+// snapshot 生成状态快照
+func (l *coreLifecycle) snapshot() CoreStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	history := make([]StateTransition, len(l.history))
+	copy(history, l.history)
+	return CoreStatus{
+		Status:      l.state.String(),
+		Reason:      l.reason,
+		Since:       l.since.UnixMilli(),
+		Transitions: history,
+	}
+}
+
+// coreRunning 核心是否处于可服务状态
+func coreRunning() bool {
+	return lifecycle.current() == StateRunning
+}
+
+// failOnPanic 在中间状态下发生panic时切换到failed，避免状态机卡在starting/stopping
+// 必须通过defer调用，panic会继续传递给导出函数入口的recover
+func failOnPanic(during CoreState) {
+	if lifecycle.current() != during {
+		return
+	}
+	if r := recover(); r != nil {
+		lifecycle.transition(StateFailed, fmt.Sprintf("%s期间发生panic: %v", during, r))
+		panic(r)
+	}
+}
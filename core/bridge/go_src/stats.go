@@ -0,0 +1,66 @@
+// 流量统计导出接口
+// 汇总核心流量信息，供Dart侧的流量监控使用
+
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"time"
+
+	"github.com/metacubex/mihomo/tunnel/statistic"
+)
+
+// TrafficStats 流量统计快照
+// 上行为应用发往TUN的流量（从TUN读出），下行为写回TUN的流量
+// Engine为mihomo隧道中所有连接（含HTTP/SOCKS入站）的统计
+type TrafficStats struct {
+	Upload     uint64 `json:"upload"`
+	Download   uint64 `json:"download"`
+	PacketsIn  uint64 `json:"packetsIn"`
+	PacketsOut uint64 `json:"packetsOut"`
+	TunActive  bool   `json:"tunActive"`
+	Uptime     int64  `json:"uptime"`
+	Timestamp  int64  `json:"timestamp"`
+
+	Engine EngineTraffic `json:"engine"`
+}
+
+// 获取流量统计信息
+//
+//export GetTrafficStats
+func GetTrafficStats() (ret *C.char) {
+	defer recoverString(&ret)
+
+	tun := tunStats.Load().snapshot()
+	stats := TrafficStats{
+		Upload:     tun.BytesIn,
+		Download:   tun.BytesOut,
+		PacketsIn:  tun.PacketsIn,
+		PacketsOut: tun.PacketsOut,
+		Uptime:     tun.Uptime(),
+		Timestamp:  time.Now().Unix(),
+	}
+
+	tunMutex.RLock()
+	stats.TunActive = tunActive
+	tunMutex.RUnlock()
+	stats.Engine = engineTraffic()
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		setLastError(wrapError(CodeSerialize, err, "流量统计序列化失败"))
+		return C.CString("{}")
+	}
+	return C.CString(string(data))
+}
+
+// 重置流量统计
+//
+//export ResetTrafficStats
+func ResetTrafficStats() (ret int32) {
+	defer recoverCode(&ret)
+
+	statistic.DefaultManager.ResetStatistic()
+	return ResetTunStats()
+}
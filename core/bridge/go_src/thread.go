@@ -0,0 +1,20 @@
+// 宿主线程标识
+// FFI调用期间goroutine固定在宿主调用线程上，可用pthread_self区分调用方
+// 该文件不能包含//export，否则preamble中的函数定义会重复生成
+
+package main
+
+/*
+#include <pthread.h>
+#include <stdint.h>
+
+static inline uint64_t mihomo_thread_id(void) {
+	return (uint64_t)(uintptr_t)pthread_self();
+}
+*/
+import "C"
+
+// currentThreadID 获取当前OS线程标识
+func currentThreadID() uint64 {
+	return uint64(C.mihomo_thread_id())
+}
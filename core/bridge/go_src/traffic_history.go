@@ -0,0 +1,152 @@
+// TUN吞吐量历史
+// 核心每秒采样一次TUN上下行速率，保留最近10分钟，图表不再依赖UI轮询间隔
+
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// 采样间隔和保留的采样数
+const (
+	trafficSampleInterval = time.Second
+	trafficHistorySize    = 600
+)
+
+// TrafficSample 一秒内的TUN速率，上行为从TUN读出的流量，下行为写回TUN的流量
+type TrafficSample struct {
+	Time       int64  `json:"time"`       // 采样时刻，Unix秒
+	Upload     uint64 `json:"upload"`     // 字节/秒
+	Download   uint64 `json:"download"`   // 字节/秒
+	PacketsIn  uint64 `json:"packetsIn"`  // 包/秒
+	PacketsOut uint64 `json:"packetsOut"` // 包/秒
+}
+
+// TrafficHistory GetTrafficHistory返回的时间序列
+type TrafficHistory struct {
+	Interval int64           `json:"interval"` // 采样间隔，秒
+	Capacity int             `json:"capacity"` // 最多保留的采样数
+	Samples  []TrafficSample `json:"samples"`
+}
+
+// trafficSampler 固定容量的采样缓冲，写满后覆盖最旧的采样
+type trafficSampler struct {
+	mu      sync.RWMutex
+	samples []TrafficSample
+	next    int
+	full    bool
+
+	// 上一次采样时的计数，仅由采样goroutine访问
+	lastStats *TunStats
+	lastSnap  tunStatsSnapshot
+	lastTime  time.Time
+}
+
+var tunSampler = &trafficSampler{samples: make([]TrafficSample, trafficHistorySize)}
+
+// run 每秒采样一次，ctx取消后退出
+func (t *trafficSampler) run(ctx context.Context) {
+	ticker := time.NewTicker(trafficSampleInterval)
+	defer ticker.Stop()
+
+	t.lastStats = tunStats.Load()
+	t.lastSnap = t.lastStats.snapshot()
+	t.lastTime = time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.sample(now)
+		}
+	}
+}
+
+// sample 根据与上次采样的计数差计算速率
+func (t *trafficSampler) sample(now time.Time) {
+	stats := tunStats.Load()
+	snap := stats.snapshot()
+
+	// ResetTunStats后计数从零开始，以零作为上次的值
+	last := t.lastSnap
+	if stats != t.lastStats {
+		last = tunStatsSnapshot{}
+	}
+
+	elapsed := now.Sub(t.lastTime).Seconds()
+	if elapsed <= 0 {
+		elapsed = trafficSampleInterval.Seconds()
+	}
+	rate := func(current, previous uint64) uint64 {
+		if current < previous {
+			return 0
+		}
+		return uint64(float64(current-previous)/elapsed + 0.5)
+	}
+
+	t.add(TrafficSample{
+		Time:       now.Unix(),
+		Upload:     rate(snap.BytesIn, last.BytesIn),
+		Download:   rate(snap.BytesOut, last.BytesOut),
+		PacketsIn:  rate(snap.PacketsIn, last.PacketsIn),
+		PacketsOut: rate(snap.PacketsOut, last.PacketsOut),
+	})
+
+	t.lastStats, t.lastSnap, t.lastTime = stats, snap, now
+}
+
+func (t *trafficSampler) add(sample TrafficSample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples[t.next] = sample
+	t.next++
+	if t.next == len(t.samples) {
+		t.next = 0
+		t.full = true
+	}
+}
+
+// query 按时间顺序返回晚于sinceTime的采样
+func (t *trafficSampler) query(sinceTime int64) TrafficHistory {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	history := TrafficHistory{
+		Interval: int64(trafficSampleInterval / time.Second),
+		Capacity: len(t.samples),
+		Samples:  []TrafficSample{},
+	}
+
+	start, count := 0, t.next
+	if t.full {
+		start, count = t.next, len(t.samples)
+	}
+	for i := 0; i < count; i++ {
+		sample := t.samples[(start+i)%len(t.samples)]
+		if sample.Time > sinceTime {
+			history.Samples = append(history.Samples, sample)
+		}
+	}
+	return history
+}
+
+// 获取最近10分钟每秒的TUN速率，sinceTime为Unix秒，只返回之后的采样，0返回全部
+// TUN未启动期间没有采样，时间序列中会出现间隔
+//
+//export GetTrafficHistory
+func GetTrafficHistory(sinceTime int64) (ret *C.char) {
+	defer recoverString(&ret)
+
+	data, err := json.Marshal(tunSampler.query(sinceTime))
+	if err != nil {
+		setLastError(wrapError(CodeSerialize, err, "流量历史序列化失败"))
+		return C.CString(`{"samples": []}`)
+	}
+	return C.CString(string(data))
+}
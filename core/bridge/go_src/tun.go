@@ -5,302 +5,363 @@ package main
 
 import (
 	"C"
-	"fmt"
+	"encoding/json"
 	"sync"
 	"time"
-	"unsafe"
-
-	// 模拟导入gVisor相关包（实际实现中需要导入真实的包）
-	_ "github.com/metacubex/gvisor-unsafe" // 占位符
 )
 
 // 全局TUN状态管理
 var (
-	tunMutex      sync.RWMutex
-	tunActive     bool
-	tunInterface  string
-	tunStats      = TunStats{
-		packetsIn:  0,
-		packetsOut: 0,
-		bytesIn:    0,
-		bytesOut:   0,
-	}
+	tunMutex     sync.RWMutex
+	tunActive    bool
+	tunInterface string
+	tunDev       tunDevice
+	tunStarted   bool
+	tunNetstack  *tunStack
+	tunStopping  bool
+	tunShutdown  *TunShutdownReport
 )
 
-// TunStats TUN流量统计
-type TunStats struct {
-	packetsIn  uint64
-	packetsOut uint64
-	bytesIn    uint64
-	bytesOut   uint64
-	startTime  time.Time
+// TunShutdownReport 最近一次TunStop的结果
+type TunShutdownReport struct {
+	Interface string `json:"interface"`
+	Time      string `json:"time"`
+	// 停止耗时，包括等待数据包处理goroutine退出
+	DurationMs int64 `json:"durationMs"`
+	// 所有处理goroutine和TCP连接都已退出
+	Completed bool `json:"completed"`
+	// 超时后仍未结束的TCP连接数
+	PendingConnections int `json:"pendingConnections"`
 }
 
-//export TunCreate
-// 创建TUN接口
-func TunCreate(interfaceName string) int {
-	tunMutex.Lock()
-	defer tunMutex.Unlock()
+// TunStatsReport GetTunStats返回的统计
+type TunStatsReport struct {
+	Interface      string             `json:"interface"`
+	Active         bool               `json:"active"`
+	Started        bool               `json:"started"`
+	Stopping       bool               `json:"stopping"`
+	PacketsIn      uint64             `json:"packetsIn"`
+	PacketsOut     uint64             `json:"packetsOut"`
+	BytesIn        uint64             `json:"bytesIn"`
+	BytesOut       uint64             `json:"bytesOut"`
+	Uptime         int64              `json:"uptime"`
+	StartTime      string             `json:"startTime"`
+	DNSHijack      []string           `json:"dnsHijack"`
+	DNSHijacked    uint64             `json:"dnsHijacked"`
+	AppFilter      *TunAppOptions     `json:"appFilter,omitempty"`
+	AppBypassed    uint64             `json:"appBypassed"`
+	AutoRoute      *TunRouteStatus    `json:"autoRoute,omitempty"`
+	LeakProtection bool               `json:"leakProtection"`
+	LastShutdown   *TunShutdownReport `json:"lastShutdown,omitempty"`
+}
 
-	if tunActive {
-		fmt.Printf("⚠️  TUN接口已在运行: %s\n", tunInterface)
-		return 1 // 已存在
+// checkTunIdle 创建设备前检查状态，调用者需持有tunMutex
+func checkTunIdle() error {
+	if tunStopping {
+		return newError(CodeBusy, "TUN接口正在停止")
 	}
-
-	tunInterface = interfaceName
-	tunActive = true
-	tunStats = TunStats{
-		startTime: time.Now(),
+	if tunActive {
+		return newError(CodeTunActive, "TUN接口已在运行: %s", tunInterface)
 	}
-
-	fmt.Printf("🌐 创建TUN接口: %s\n", interfaceName)
-	fmt.Printf("📊 TUN统计重置 - 开始时间: %s\n", tunStats.startTime.Format("2006-01-02 15:04:05"))
-	return 0 // 成功
+	return nil
 }
 
-//export TunStart
-// 启动TUN流量处理
-func TunStart() int {
+// 创建TUN接口，使用SetTunInterface设置的MTU和地址
+//
+//export TunCreate
+func TunCreate(cInterfaceName *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
 	tunMutex.Lock()
 	defer tunMutex.Unlock()
 
-	if !tunActive {
-		fmt.Printf("❌ TUN接口未创建，无法启动\n")
-		return 1 // 未创建
+	interfaceName := C.GoString(cInterfaceName)
+	if err := checkTunIdle(); err != nil {
+		return setLastError(err)
 	}
 
-	fmt.Printf("🚀 启动TUN流量处理 - 接口: %s\n", tunInterface)
+	cfg := currentTunConfig
+	if interfaceName != "" {
+		cfg.Name = interfaceName
+	}
+
+	device, err := createTunDevice(cfg)
+	if err != nil {
+		return setLastError(err)
+	}
 
-	// 启动TUN处理循环（在实际实现中，这里会启动数据包处理协程）
-	go tunProcessingLoop()
+	installTunDevice(device)
 
-	return 0 // 成功
+	tunLog.Infof("创建TUN接口: %s (MTU: %d, 地址: %v)", tunInterface, device.MTU(), cfg.Addresses)
+	return CodeSuccess
 }
 
-//export TunStop
-// 停止TUN流量处理
-func TunStop() int {
+// 接管宿主创建的TUN文件描述符（Android VpnService、iOS utun等）
+// 之后由核心读写数据包，TunStop时关闭该fd；接口地址仍以SetTunInterface记录的为准
+//
+//export TunAttachFd
+func TunAttachFd(fd, mtu int32) (ret int32) {
+	defer recoverCode(&ret)
+
+	if fd < 0 {
+		return failf(CodeInvalidArgument, "无效的TUN文件描述符: %d", fd)
+	}
+	if mtu <= 0 {
+		mtu = defaultTunMTU
+	}
+	if mtu < 576 || mtu > 65535 {
+		return failf(CodeInvalidArgument, "无效的MTU: %d（有效范围576-65535）", mtu)
+	}
+
 	tunMutex.Lock()
 	defer tunMutex.Unlock()
 
-	if !tunActive {
-		fmt.Printf("⚠️  TUN接口未在运行\n")
-		return 1 // 未运行
+	if err := checkTunIdle(); err != nil {
+		return setLastError(err)
 	}
 
-	fmt.Printf("🛑 停止TUN流量处理 - 接口: %s\n", tunInterface)
+	device, err := attachTunDevice(int(fd), int(mtu))
+	if err != nil {
+		return setLastError(err)
+	}
 
-	tunActive = false
-	tunInterface = ""
+	installTunDevice(device)
 
-	// 打印最终统计
-	fmt.Printf("📊 TUN流量统计 - 期间: %s\n", time.Since(tunStats.startTime))
-	fmt.Printf("📦 入站: %d 包 (%d 字节)\n", tunStats.packetsIn, tunStats.bytesIn)
-	fmt.Printf("📦 出站: %d 包 (%d 字节)\n", tunStats.packetsOut, tunStats.bytesOut)
+	tunLog.Infof("接管TUN文件描述符: %d (MTU: %d)", fd, mtu)
+	return CodeSuccess
+}
 
-	return 0 // 成功
+// installTunDevice 记录新创建的设备并重置统计，调用者需持有tunMutex
+func installTunDevice(device tunDevice) {
+	tunDev = device
+	tunInterface = device.Name()
+	tunActive = true
+	resetTunStats()
 }
 
-//export TunReadPacket
-// 从TUN接口读取数据包
-func TunReadPacket() *C.char {
-	tunMutex.RLock()
-	defer tunMutex.RUnlock()
+// 启动TUN流量处理
+//
+//export TunStart
+func TunStart() (ret int32) {
+	defer recoverCode(&ret)
+
+	tunMutex.Lock()
+	defer tunMutex.Unlock()
 
 	if !tunActive {
-		return C.CString(`{"error": "tun not active"}`)
+		return failf(CodeTunNotCreated, "TUN接口未创建，无法启动")
+	}
+
+	if !coreRunning() {
+		return failf(CodeNotRunning, "代理未运行，无法启动TUN流量处理")
 	}
 
-	// 模拟数据包读取（在实际实现中，这里会从TUN fd读取真实数据包）
-	packet := simulateTunRead()
+	if tunStarted {
+		return failf(CodeRunning, "TUN流量处理已在运行: %s", tunInterface)
+	}
 
-	if packet != nil {
-		// 更新统计
-		tunStats.packetsIn++
-		tunStats.bytesIn += uint64(len(packet))
+	// 先引入路由再启动协议栈，期间到达的数据包在设备队列中等待读取
+	if err := startTunRoutes(tunInterface, currentTunConfig); err != nil {
+		return setLastError(err)
+	}
 
-		fmt.Printf("📥 TUN读取数据包: %d 字节\n", len(packet))
-		return C.CString(packet)
+	netstack, err := newTunStack(tunDev)
+	if err != nil {
+		stopTunRoutes()
+		return setLastError(err)
 	}
 
-	return C.CString(`{"data": null}`)
+	tunLog.Infof("启动TUN流量处理 - 接口: %s", tunInterface)
+
+	tunStarted = true
+	tunNetstack = netstack
+
+	return CodeSuccess
 }
 
-//export TunWritePacket
-// 向TUN接口写入数据包
-func TunWritePacket(packetData string) int {
-	tunMutex.RLock()
-	defer tunMutex.RUnlock()
+// 停止TUN流量处理，等待数据包处理goroutine全部退出后返回
+// TCP连接的处理goroutine最多等待netstackCloseTimeout，超时后留在后台，数量记录在lastShutdown
+//
+//export TunStop
+func TunStop() (ret int32) {
+	defer recoverCode(&ret)
 
+	tunMutex.Lock()
+	if tunStopping {
+		tunMutex.Unlock()
+		return failf(CodeBusy, "TUN接口正在停止")
+	}
 	if !tunActive {
-		fmt.Printf("❌ TUN接口未活跃，无法写入数据包\n")
-		return 1 // 未活跃
+		tunMutex.Unlock()
+		return failf(CodeNotRunning, "TUN接口未在运行")
 	}
 
-	// 更新统计
-	tunStats.packetsOut++
-	tunStats.bytesOut += uint64(len(packetData))
+	tunLog.Infof("停止TUN流量处理 - 接口: %s", tunInterface)
 
-	fmt.Printf("📤 TUN写入数据包: %d 字节\n", len(packetData))
+	// 先摘下设备再释放锁，等待处理goroutine退出期间不阻塞状态查询
+	device, netstack, name := tunDev, tunNetstack, tunInterface
+	tunDev = nil
+	tunNetstack = nil
+	tunActive = false
+	tunStarted = false
+	tunInterface = ""
+	tunStopping = true
+	tunMutex.Unlock()
 
-	// 模拟数据包写入（在实际实现中，这里会向TUN fd写入真实数据包）
-	return 0 // 成功
+	report := shutdownTun(name, device, netstack)
+
+	tunMutex.Lock()
+	defer tunMutex.Unlock()
+	tunStopping = false
+	tunShutdown = &report
+
+	// 打印最终统计
+	stats := tunStats.Load().snapshot()
+	tunLog.Infof("TUN流量统计 - 期间: %s, 入站: %d 包 (%d 字节), 出站: %d 包 (%d 字节)",
+		time.Since(stats.StartTime), stats.PacketsIn, stats.BytesIn, stats.PacketsOut, stats.BytesOut)
+
+	return CodeSuccess
 }
 
-//export GetTunStats
-// 获取TUN流量统计
-func GetTunStats() *C.char {
-	tunMutex.RLock()
-	defer tunMutex.RUnlock()
+// shutdownTun 关闭设备和协议栈并等待处理goroutine退出
+func shutdownTun(name string, device tunDevice, netstack *tunStack) (report TunShutdownReport) {
+	begin := time.Now()
+	report = TunShutdownReport{Interface: name, Completed: true}
+	defer func() {
+		report.DurationMs = time.Since(begin).Milliseconds()
+		report.Time = time.Now().Format("2006-01-02 15:04:05")
+	}()
+
+	// 先撤销自动路由，流量回到原来的路由后再关闭设备
+	stopTunRoutes()
+
+	// 关闭设备即销毁接口，阻塞中的Read随之返回
+	if err := device.Close(); err != nil {
+		tunLog.Warnf("关闭TUN设备失败: %v", err)
+	}
+	if netstack == nil {
+		return report
+	}
 
-	statsJSON := fmt.Sprintf(`{
-		"interface": "%s",
-		"active": %t,
-		"packetsIn": %d,
-		"packetsOut": %d,
-		"bytesIn": %d,
-		"bytesOut": %d,
-		"uptime": %d,
-		"startTime": "%s"
-	}`,
-		tunInterface,
-		tunActive,
-		tunStats.packetsIn,
-		tunStats.packetsOut,
-		tunStats.bytesIn,
-		tunStats.bytesOut,
-		time.Since(tunStats.startTime).Seconds(),
-		tunStats.startTime.Format("2006-01-02 15:04:05"),
-	)
-
-	return C.CString(statsJSON)
+	report.PendingConnections = netstack.Close(netstackCloseTimeout)
+	if report.PendingConnections > 0 {
+		report.Completed = false
+		tunLog.Warnf("TUN停止超时，仍有%d个TCP连接未结束", report.PendingConnections)
+	}
+	return report
 }
 
-//export ResetTunStats
-// 重置TUN统计
-func ResetTunStats() int {
-	tunMutex.Lock()
-	defer tunMutex.Unlock()
+// 获取TUN流量统计
+//
+//export GetTunStats
+func GetTunStats() (ret *C.char) {
+	defer recoverString(&ret)
+
+	current := tunStats.Load()
+	stats := current.snapshot()
 
-	fmt.Printf("📊 重置TUN流量统计\n")
-	tunStats = TunStats{
-		startTime: time.Now(),
+	tunMutex.RLock()
+	report := TunStatsReport{
+		Interface:      tunInterface,
+		Active:         tunActive,
+		Started:        tunStarted,
+		Stopping:       tunStopping,
+		PacketsIn:      stats.PacketsIn,
+		PacketsOut:     stats.PacketsOut,
+		BytesIn:        stats.BytesIn,
+		BytesOut:       stats.BytesOut,
+		Uptime:         stats.Uptime(),
+		StartTime:      stats.StartTime.Format("2006-01-02 15:04:05"),
+		DNSHijack:      dnsHijackStrings(),
+		DNSHijacked:    current.dnsHijacked.Load(),
+		AppFilter:      tunAppOptions(),
+		AppBypassed:    current.appBypassed.Load(),
+		AutoRoute:      tunRouteStatus(),
+		LeakProtection: tunLeakProtection(),
+		LastShutdown:   tunShutdown,
 	}
+	tunMutex.RUnlock()
 
-	return 0
+	data, err := json.Marshal(report)
+	if err != nil {
+		setLastError(wrapError(CodeSerialize, err, "TUN统计序列化失败"))
+		return C.CString(`{"active": false}`)
+	}
+	return C.CString(string(data))
 }
 
-//export SetTunInterface
-// 设置TUN接口参数
-func SetTunInterface(interfaceName, mtu, address string) int {
-	tunMutex.Lock()
-	defer tunMutex.Unlock()
+// 重置TUN统计
+//
+//export ResetTunStats
+func ResetTunStats() (ret int32) {
+	defer recoverCode(&ret)
 
-	fmt.Printf("⚙️  设置TUN接口参数: %s, MTU: %s, 地址: %s\n", interfaceName, mtu, address)
-	tunInterface = interfaceName
+	tunLog.Infof("重置TUN流量统计")
+	resetTunStats()
 
-	return 0
+	return CodeSuccess
 }
 
-// tunProcessingLoop TUN处理循环
-func tunProcessingLoop() {
-	fmt.Printf("🔄 TUN处理循环启动\n")
-
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+// 设置TUN接口参数，接口已创建时立即应用到设备
+// address为逗号分隔的地址，同时给出IPv4和IPv6地址时为双栈
+//
+//export SetTunInterface
+func SetTunInterface(cInterfaceName, cMtu, cAddress *C.char) (ret int32) {
+	defer recoverCode(&ret)
 
-	for {
-		select {
-		case <-ticker.C:
-			// 定期处理数据包
-			if !tunActive {
-				break
-			}
+	interfaceName := C.GoString(cInterfaceName)
+	mtu, err := parseTunMTU(C.GoString(cMtu))
+	if err != nil {
+		return setLastError(err)
+	}
+	addresses, err := parseTunAddresses(C.GoString(cAddress))
+	if err != nil {
+		return setLastError(err)
+	}
 
-			// 模拟数据包处理
-			processSimulatedPacket()
+	tunMutex.Lock()
+	defer tunMutex.Unlock()
 
-		default:
-			// 非阻塞检查
-			time.Sleep(10 * time.Millisecond)
+	cfg := tunConfig{Name: interfaceName, MTU: mtu, Addresses: addresses}
+	if tunActive {
+		if interfaceName != "" && interfaceName != tunInterface {
+			return failf(CodeTunActive, "TUN接口已创建为%s，不能改名为%s", tunInterface, interfaceName)
 		}
-
-		if !tunActive {
-			break
+		if err := configureTunDevice(tunDev, cfg); err != nil {
+			return setLastError(err)
 		}
 	}
+	currentTunConfig = cfg
+	setTunDNSIPv6(cfg)
 
-	fmt.Printf("🔄 TUN处理循环结束\n")
-}
-
-// simulateTunRead 模拟从TUN读取数据包
-func simulateTunRead() string {
-	// 生成模拟数据包
-	// 这里应该返回真实的IP数据包，但在模拟环境中返回JSON格式的模拟数据
-	timestamp := time.Now().Format("15:04:05.000")
-	return fmt.Sprintf(`{
-		"timestamp": "%s",
-		"src": "10.0.0.2:12345",
-		"dst": "8.8.8.8:53",
-		"protocol": "udp",
-		"data": "模拟DNS查询数据包",
-		"size": 64
-	}`, timestamp)
-}
-
-// processSimulatedPacket 模拟数据包处理
-func processSimulatedPacket() {
-	// 这里应该实现真实的gVisor数据包处理逻辑
-	// 1. 解析IP数据包
-	// 2. 根据路由规则处理
-	// 3. 如果需要代理，发送到目标服务器
-	// 4. 处理返回的数据包并写入TUN接口
-
-	// 模拟处理延迟
-	time.Sleep(1 * time.Millisecond)
+	tunLog.Infof("设置TUN接口参数: %s, MTU: %d, 地址: %v", interfaceName, mtu, addresses)
+	return CodeSuccess
 }
 
 // GetTunStatus 获取TUN状态信息
 func GetTunStatus() map[string]interface{} {
+	stats := tunStats.Load().snapshot()
+
 	tunMutex.RLock()
 	defer tunMutex.RUnlock()
 
 	return map[string]interface{}{
 		"active":     tunActive,
 		"interface":  tunInterface,
-		"uptime":     time.Since(tunStats.startTime).Seconds(),
-		"packetsIn":  tunStats.packetsIn,
-		"packetsOut": tunStats.packetsOut,
-		"bytesIn":    tunStats.bytesIn,
-		"bytesOut":   tunStats.bytesOut,
+		"uptime":     stats.Uptime(),
+		"packetsIn":  stats.PacketsIn,
+		"packetsOut": stats.PacketsOut,
+		"bytesIn":    stats.BytesIn,
+		"bytesOut":   stats.BytesOut,
 	}
 }
 
 // 内存管理辅助函数
-//export FreeTunString
 // 释放TUN相关字符串内存
+//
+//export FreeTunString
 func FreeTunString(str *C.char) {
-	if str != nil {
-		C.free(unsafe.Pointer(str))
-	}
-}
-
-// CGO桥接函数声明
-// 这些函数在实际的gVisor集成中会被替换
-/*
-import "C"
-
-//export TunProcessPacket
-// 处理单个数据包
-func TunProcessPacket(packetData []byte) []byte {
-	// 这里会调用真实的gVisor处理逻辑
-	return processPacketWithGVisor(packetData)
-}
+	defer recoverVoid()
 
-//export TunInitializeGVisor
-// 初始化gVisor
-func TunInitializeGVisor(configPath string) bool {
-	// 初始化gVisor运行时
-	return initializeGVisor(configPath)
+	FreeString(str)
 }
-*/
\ No newline at end of file
@@ -0,0 +1,272 @@
+// TUN分应用代理
+// include-package/exclude-package（Android包名）和include-uid/exclude-uid（Linux用户ID）决定哪些应用经过代理：
+//   - 构建路由：Linux自动路由为不经过代理的UID添加uidrange规则，这些应用的流量不再进入TUN；
+//     Android的路由由宿主构建，需把同样的包名交给VpnService（addAllowedApplication/addDisallowedApplication）
+//   - 匹配连接：仍进入TUN的连接按所属应用判断，不经过代理的应用改走DIRECT；所属应用的查找见tun_owner.go
+// 同时设置include和exclude时，只代理在include中且不在exclude中的应用；
+// 设置了include时，找不到所属应用的连接（如转发自其他设备）也不经过代理
+
+package main
+
+import (
+	"C"
+	"cmp"
+	"encoding/json"
+	"math"
+	"net"
+	"net/netip"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/metacubex/mihomo/adapter/inbound"
+)
+
+// 只有Android能按包名找到连接所属的应用（由宿主查询），Linux和Android都能找到UID
+const (
+	tunAppPackageSupported = runtime.GOOS == "android"
+	tunAppUIDSupported     = runtime.GOOS == "linux" || runtime.GOOS == "android"
+)
+
+// TunAppOptions SetTunAppFilter的参数
+type TunAppOptions struct {
+	IncludePackage []string `json:"include-package,omitempty"`
+	ExcludePackage []string `json:"exclude-package,omitempty"`
+	IncludeUID     []uint32 `json:"include-uid,omitempty"`
+	ExcludeUID     []uint32 `json:"exclude-uid,omitempty"`
+}
+
+// tunAppFilter 解析后的分应用列表
+type tunAppFilter struct {
+	options         TunAppOptions // 去重排序后的参数，出现在GetTunStats的appFilter字段
+	includePackages map[string]struct{}
+	excludePackages map[string]struct{}
+	includeUIDs     map[uint32]struct{}
+	excludeUIDs     map[uint32]struct{}
+}
+
+// maxUID 最大的有效UID，(uid_t)-1为无效UID，内核不接受包含它的uidrange
+const maxUID = math.MaxUint32 - 1
+
+// uidRange 闭区间[start, end]内的UID
+type uidRange struct {
+	start, end uint32
+}
+
+// String 转换为ip rule的uidrange形式
+func (r uidRange) String() string {
+	return strconv.FormatUint(uint64(r.start), 10) + "-" + strconv.FormatUint(uint64(r.end), 10)
+}
+
+// tunAppFilterValue 当前分应用列表，nil表示所有应用都经过代理，新建连接时读取
+var tunAppFilterValue atomic.Pointer[tunAppFilter]
+
+// parseTunAppOptions 解析JSON参数，空字符串或列表全部为空时返回nil
+func parseTunAppOptions(text string) (*tunAppFilter, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	var options TunAppOptions
+	if err := json.Unmarshal([]byte(text), &options); err != nil {
+		return nil, wrapError(CodeInvalidArgument, err, "无效的分应用参数")
+	}
+	for _, list := range [][]string{options.IncludePackage, options.ExcludePackage} {
+		for _, name := range list {
+			if name == "" {
+				return nil, newError(CodeInvalidArgument, "包名不能为空")
+			}
+		}
+	}
+
+	hasPackages := len(options.IncludePackage) > 0 || len(options.ExcludePackage) > 0
+	hasUIDs := len(options.IncludeUID) > 0 || len(options.ExcludeUID) > 0
+	if !hasPackages && !hasUIDs {
+		return nil, nil
+	}
+	if hasPackages && !tunAppPackageSupported {
+		return nil, newError(CodeTunUnsupported, "当前平台不支持按包名分应用代理")
+	}
+	if hasUIDs && !tunAppUIDSupported {
+		return nil, newError(CodeTunUnsupported, "当前平台不支持按UID分应用代理")
+	}
+
+	f := &tunAppFilter{}
+	f.options.IncludePackage, f.includePackages = normalizeAppList(options.IncludePackage)
+	f.options.ExcludePackage, f.excludePackages = normalizeAppList(options.ExcludePackage)
+	f.options.IncludeUID, f.includeUIDs = normalizeAppList(options.IncludeUID)
+	f.options.ExcludeUID, f.excludeUIDs = normalizeAppList(options.ExcludeUID)
+	return f, nil
+}
+
+// normalizeAppList 去重排序，同时生成查找用的集合
+func normalizeAppList[T string | uint32](list []T) ([]T, map[T]struct{}) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	sorted := slices.Clone(list)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	set := make(map[T]struct{}, len(sorted))
+	for _, item := range sorted {
+		set[item] = struct{}{}
+	}
+	return sorted, set
+}
+
+// allows 判断连接所属的应用是否经过代理，found为false表示找不到所属应用
+// 共享UID的多个包中任意一个被排除即不经过代理，任意一个被包含即经过代理
+func (f *tunAppFilter) allows(owner tunAppOwner, found bool) bool {
+	if found {
+		if _, ok := f.excludeUIDs[owner.uid]; ok {
+			return false
+		}
+		if containsAny(f.excludePackages, owner.packages) {
+			return false
+		}
+	}
+	if len(f.includeUIDs) == 0 && len(f.includePackages) == 0 {
+		return true
+	}
+	if !found {
+		return false
+	}
+	if _, ok := f.includeUIDs[owner.uid]; ok {
+		return true
+	}
+	return containsAny(f.includePackages, owner.packages)
+}
+
+// containsAny 列表中是否有元素在集合中
+func containsAny(set map[string]struct{}, list []string) bool {
+	for _, item := range list {
+		if _, ok := set[item]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPackages 分应用列表是否包含包名，不包含时不查询连接所属应用的包名
+func (f *tunAppFilter) hasPackages() bool {
+	return len(f.includePackages) > 0 || len(f.excludePackages) > 0
+}
+
+// bypassUIDRanges 不经过代理的UID区间：exclude-uid以及include-uid之外的全部UID，已合并排序
+func (f *tunAppFilter) bypassUIDRanges() []uidRange {
+	if f == nil {
+		return nil
+	}
+
+	ranges := uidRanges(f.options.ExcludeUID)
+	if len(f.options.IncludeUID) > 0 {
+		next := uint64(0)
+		for _, r := range uidRanges(f.options.IncludeUID) {
+			if uint64(r.start) > next {
+				ranges = append(ranges, uidRange{uint32(next), r.start - 1})
+			}
+			next = uint64(r.end) + 1
+		}
+		if next <= maxUID {
+			ranges = append(ranges, uidRange{uint32(next), maxUID})
+		}
+	}
+	return mergeUIDRanges(ranges)
+}
+
+// uidRanges 把排序后的UID列表中连续的UID合并为区间
+func uidRanges(uids []uint32) []uidRange {
+	ranges := []uidRange{}
+	for _, uid := range uids {
+		if n := len(ranges); n > 0 && ranges[n-1].end+1 == uid {
+			ranges[n-1].end = uid
+			continue
+		}
+		ranges = append(ranges, uidRange{uid, uid})
+	}
+	return ranges
+}
+
+// mergeUIDRanges 排序并合并重叠或相邻的区间
+func mergeUIDRanges(ranges []uidRange) []uidRange {
+	slices.SortFunc(ranges, func(a, b uidRange) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	merged := []uidRange{}
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && uint64(r.start) <= uint64(merged[n-1].end)+1 {
+			merged[n-1].end = max(merged[n-1].end, r.end)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// tunAppAdditions 新建TUN连接时按所属应用决定是否经过代理，不经过代理时返回改走DIRECT的附加信息
+// source为应用一侧的地址，destination为目的地址，未设置分应用列表时不查找所属应用
+func tunAppAdditions(network string, source, destination net.Addr) []inbound.Addition {
+	f := tunAppFilterValue.Load()
+	if f == nil {
+		return nil
+	}
+
+	src := netip.AddrPortFrom(addrIP(source), addrPort(source))
+	dst := netip.AddrPortFrom(addrIP(destination), addrPort(destination))
+	owner, found := findTunAppOwner(network, src, dst, f.hasPackages())
+
+	if f.allows(owner, found) {
+		return nil
+	}
+	tunStats.Load().appBypassed.Add(1)
+	tunLog.Debugf("TUN连接 %s (uid=%d %v) 不经过代理，改走DIRECT", source, owner.uid, owner.packages)
+	return []inbound.Addition{inbound.WithSpecialProxy("DIRECT")}
+}
+
+// tunAppOptions 当前分应用参数，未设置时为nil
+func tunAppOptions() *TunAppOptions {
+	f := tunAppFilterValue.Load()
+	if f == nil {
+		return nil
+	}
+	return &f.options
+}
+
+// 设置TUN分应用代理，options为JSON {"include-package","exclude-package","include-uid","exclude-uid"}，
+// 空字符串或列表全部为空时所有应用都经过代理
+// 包名只在Android上生效，UID在Linux和Android上生效；Linux自动路由已安装时立即按新列表重新安装
+//
+//export SetTunAppFilter
+func SetTunAppFilter(cOptions *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
+	f, err := parseTunAppOptions(C.GoString(cOptions))
+	if err != nil {
+		return setLastError(err)
+	}
+
+	tunMutex.RLock()
+	defer tunMutex.RUnlock()
+	tunRouteMu.Lock()
+	defer tunRouteMu.Unlock()
+
+	tunAppFilterValue.Store(f)
+	if f == nil {
+		tunLog.Infof("关闭TUN分应用代理")
+	} else {
+		tunLog.Infof("TUN分应用代理: include-package=%v exclude-package=%v include-uid=%v exclude-uid=%v",
+			f.options.IncludePackage, f.options.ExcludePackage, f.options.IncludeUID, f.options.ExcludeUID)
+		if tunAppPackageSupported && !hostOwnerAvailable() {
+			tunLog.Warnf("宿主未通过SetConnectionOwnerResolver注册连接归属查询，TUN连接都按找不到所属应用处理")
+		}
+	}
+
+	if installed := tunRouteInstalled; installed != nil && tunRouteSettings != nil {
+		if err := installTunRoutesLocked(installed.Interface, currentTunConfig); err != nil {
+			return setLastError(err)
+		}
+	}
+	return CodeSuccess
+}
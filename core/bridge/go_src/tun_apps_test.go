@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestTunAppFilterAllows(t *testing.T) {
+	// 直接构造过滤器，parseTunAppOptions在Linux上不接受包名
+	newFilter := func(options TunAppOptions) *tunAppFilter {
+		f := &tunAppFilter{}
+		f.options.IncludePackage, f.includePackages = normalizeAppList(options.IncludePackage)
+		f.options.ExcludePackage, f.excludePackages = normalizeAppList(options.ExcludePackage)
+		f.options.IncludeUID, f.includeUIDs = normalizeAppList(options.IncludeUID)
+		f.options.ExcludeUID, f.excludeUIDs = normalizeAppList(options.ExcludeUID)
+		return f
+	}
+	shared := tunAppOwner{uid: 10100, packages: []string{"com.example.a", "com.example.b"}}
+
+	tests := []struct {
+		name    string
+		options TunAppOptions
+		owner   tunAppOwner
+		found   bool
+		want    bool
+	}{
+		{name: "排除UID", options: TunAppOptions{ExcludeUID: []uint32{1000}}, owner: tunAppOwner{uid: 1000}, found: true, want: false},
+		{name: "排除列表找不到所属应用", options: TunAppOptions{ExcludeUID: []uint32{1000}}, found: false, want: true},
+		{name: "包含UID", options: TunAppOptions{IncludeUID: []uint32{1000}}, owner: tunAppOwner{uid: 1000}, found: true, want: true},
+		{name: "不在包含列表", options: TunAppOptions{IncludeUID: []uint32{1000}}, owner: tunAppOwner{uid: 1001}, found: true, want: false},
+		{name: "包含列表找不到所属应用", options: TunAppOptions{IncludeUID: []uint32{1000}}, found: false, want: false},
+		{name: "共享UID的包被排除", options: TunAppOptions{ExcludePackage: []string{"com.example.b"}}, owner: shared, found: true, want: false},
+		{name: "共享UID的包被包含", options: TunAppOptions{IncludePackage: []string{"com.example.b"}}, owner: shared, found: true, want: true},
+		{name: "包含且排除", options: TunAppOptions{IncludeUID: []uint32{10100}, ExcludePackage: []string{"com.example.a"}}, owner: shared, found: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newFilter(tt.options).allows(tt.owner, tt.found); got != tt.want {
+				t.Fatalf("allows = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
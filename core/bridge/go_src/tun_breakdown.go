@@ -0,0 +1,257 @@
+// TUN流量分类统计
+// 按传输层协议、IP版本和目的地址拆分流量，供性能监控显示带宽去向
+// 协议和IP版本在数据包路径上原子计数；目的地址按连接计数，只在新建连接时加锁
+
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/metacubex/gvisor/pkg/tcpip/adapters/gonet"
+	"github.com/metacubex/gvisor/pkg/tcpip/header"
+	"github.com/metacubex/mihomo/component/resolver"
+)
+
+// 传输层协议分类
+const (
+	tunProtocolTCP = iota
+	tunProtocolUDP
+	tunProtocolICMP
+	tunProtocolOther
+	tunProtocolCount
+)
+
+var tunProtocolNames = [tunProtocolCount]string{"tcp", "udp", "icmp", "other"}
+
+// IP版本分类
+const (
+	tunIPv4 = iota
+	tunIPv6
+	tunIPVersionCount
+)
+
+var tunIPVersionNames = [tunIPVersionCount]string{"ipv4", "ipv6"}
+
+// 目的地址表的容量，超出后新的目的地址计入tunDestinationOverflow
+const (
+	tunDestinationLimit    = 4096
+	tunDestinationOverflow = "other"
+	defaultTopDestinations = 10
+)
+
+// tunTrafficPair 一个分类的双向计数
+type tunTrafficPair struct {
+	in  tunCounter
+	out tunCounter
+}
+
+// TrafficCounters 一个分类的统计
+type TrafficCounters struct {
+	PacketsIn  uint64 `json:"packetsIn"`
+	PacketsOut uint64 `json:"packetsOut"`
+	BytesIn    uint64 `json:"bytesIn"`
+	BytesOut   uint64 `json:"bytesOut"`
+}
+
+func (p *tunTrafficPair) snapshot() TrafficCounters {
+	var c TrafficCounters
+	c.PacketsIn, c.BytesIn = p.in.load()
+	c.PacketsOut, c.BytesOut = p.out.load()
+	return c
+}
+
+// classifyPacket 解析IP头得到IP版本和传输层协议，不是IP包时ok为false
+// IPv6只看固定头的下一个头字段，带扩展头的包归入other
+func classifyPacket(packet []byte) (version, protocol int, ok bool) {
+	var transport uint8
+	switch header.IPVersion(packet) {
+	case header.IPv4Version:
+		if len(packet) < header.IPv4MinimumSize {
+			return 0, 0, false
+		}
+		version, transport = tunIPv4, header.IPv4(packet).Protocol()
+	case header.IPv6Version:
+		if len(packet) < header.IPv6MinimumSize {
+			return 0, 0, false
+		}
+		version, transport = tunIPv6, header.IPv6(packet).NextHeader()
+	default:
+		return 0, 0, false
+	}
+
+	switch transport {
+	case uint8(header.TCPProtocolNumber):
+		protocol = tunProtocolTCP
+	case uint8(header.UDPProtocolNumber):
+		protocol = tunProtocolUDP
+	case uint8(header.ICMPv4ProtocolNumber), uint8(header.ICMPv6ProtocolNumber):
+		protocol = tunProtocolICMP
+	default:
+		protocol = tunProtocolOther
+	}
+	return version, protocol, true
+}
+
+// tunDestination 一个目的地址的连接数和载荷字节数
+type tunDestination struct {
+	ip          string
+	host        string
+	connections atomic.Uint64
+	upload      atomic.Uint64
+	download    atomic.Uint64
+}
+
+// tunDestinationTable 按目的IP汇总的连接统计
+type tunDestinationTable struct {
+	mu      sync.Mutex
+	entries map[string]*tunDestination
+}
+
+// open 新建连接时登记目的地址，fake-ip和DNS映射能查到域名时一并记录
+func (t *tunDestinationTable) open(addr net.Addr) *tunDestination {
+	ip := addrIP(addr)
+	key := ip.String()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.entries == nil {
+		t.entries = make(map[string]*tunDestination)
+	}
+	dest, ok := t.entries[key]
+	if !ok {
+		if len(t.entries) >= tunDestinationLimit {
+			key = tunDestinationOverflow
+			dest = t.entries[key]
+		}
+		if dest == nil {
+			dest = &tunDestination{ip: key}
+			// 溢出条目汇总多个地址，不对应某个域名，无需查找
+			if key != tunDestinationOverflow {
+				if host, found := resolver.FindHostByIP(ip); found {
+					dest.host = host
+				}
+			}
+			t.entries[key] = dest
+		}
+	}
+	dest.connections.Add(1)
+	return dest
+}
+
+// DestinationStats 一个目的地址的统计，字节数为TCP/UDP载荷
+type DestinationStats struct {
+	IP          string `json:"ip"`
+	Host        string `json:"host,omitempty"`
+	Connections uint64 `json:"connections"`
+	Upload      uint64 `json:"upload"`
+	Download    uint64 `json:"download"`
+}
+
+// top 按总字节数返回前n个目的地址及目的地址总数
+func (t *tunDestinationTable) top(n int) ([]DestinationStats, int) {
+	t.mu.Lock()
+	list := make([]DestinationStats, 0, len(t.entries))
+	for _, dest := range t.entries {
+		list = append(list, DestinationStats{
+			IP:          dest.ip,
+			Host:        dest.host,
+			Connections: dest.connections.Load(),
+			Upload:      dest.upload.Load(),
+			Download:    dest.download.Load(),
+		})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Upload+list[i].Download > list[j].Upload+list[j].Download
+	})
+	total := len(list)
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list, total
+}
+
+// addrIP 取出net.Addr中的IP
+func addrIP(addr net.Addr) netip.Addr {
+	var ip netip.Addr
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, _ = netip.AddrFromSlice(a.IP)
+	case *net.UDPAddr:
+		ip, _ = netip.AddrFromSlice(a.IP)
+	}
+	return ip.Unmap()
+}
+
+// tunCountedConn 统计一个TCP连接的载荷，读为上行、写为下行
+type tunCountedConn struct {
+	*gonet.TCPConn
+	dest *tunDestination
+}
+
+func (c *tunCountedConn) Read(b []byte) (int, error) {
+	n, err := c.TCPConn.Read(b)
+	c.dest.upload.Add(uint64(n))
+	return n, err
+}
+
+func (c *tunCountedConn) Write(b []byte) (int, error) {
+	n, err := c.TCPConn.Write(b)
+	c.dest.download.Add(uint64(n))
+	return n, err
+}
+
+// TrafficBreakdown GetTunTrafficBreakdown返回的分类统计
+type TrafficBreakdown struct {
+	Protocols        map[string]TrafficCounters `json:"protocols"`
+	IPVersions       map[string]TrafficCounters `json:"ipVersions"`
+	Destinations     []DestinationStats         `json:"destinations"`
+	DestinationCount int                        `json:"destinationCount"`
+	StartTime        string                     `json:"startTime"`
+}
+
+// breakdown 汇总分类统计
+func (s *TunStats) breakdown(topN int) TrafficBreakdown {
+	result := TrafficBreakdown{
+		Protocols:  make(map[string]TrafficCounters, tunProtocolCount),
+		IPVersions: make(map[string]TrafficCounters, tunIPVersionCount),
+	}
+	for i := range s.protocols {
+		result.Protocols[tunProtocolNames[i]] = s.protocols[i].snapshot()
+	}
+	for i := range s.versions {
+		result.IPVersions[tunIPVersionNames[i]] = s.versions[i].snapshot()
+	}
+	result.Destinations, result.DestinationCount = s.destinations.top(topN)
+	if !s.startTime.IsZero() {
+		result.StartTime = s.startTime.Format("2006-01-02 15:04:05")
+	}
+	return result
+}
+
+// 获取按协议、IP版本和目的地址拆分的TUN流量，topN为返回的目的地址数，<=0时默认10
+// 协议和IP版本按IP包计数；目的地址按经协议栈转发的TCP/UDP连接计数，字节数为载荷
+//
+//export GetTunTrafficBreakdown
+func GetTunTrafficBreakdown(topN int32) (ret *C.char) {
+	defer recoverString(&ret)
+
+	if topN <= 0 {
+		topN = defaultTopDestinations
+	}
+
+	data, err := json.Marshal(tunStats.Load().breakdown(int(topN)))
+	if err != nil {
+		setLastError(wrapError(CodeSerialize, err, "TUN分类统计序列化失败"))
+		return C.CString("{}")
+	}
+	return C.CString(string(data))
+}
@@ -0,0 +1,453 @@
+// TUN抓包
+// 把TUN路径上的数据包写入pcapng文件，供排查TUN模式下应用异常时用Wireshark分析
+// 未抓包时数据包路径上只有一次原子读取
+
+package main
+
+import (
+	"C"
+	"bufio"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 抓包文件默认大小上限
+const defaultCaptureMaxSize = 100 << 20
+
+// CaptureSummary 一次抓包的结果
+type CaptureSummary struct {
+	Path      string `json:"path"`
+	Filter    string `json:"filter,omitempty"`
+	Packets   uint64 `json:"packets"`   // 写入文件的包数
+	Skipped   uint64 `json:"skipped"`   // 被过滤条件排除的包数
+	Size      int64  `json:"size"`      // 文件字节数
+	MaxSize   int64  `json:"maxSize"`   // 文件大小上限
+	Truncated bool   `json:"truncated"` // 达到大小上限后自动停止
+	Error     string `json:"error,omitempty"`
+	StartTime string `json:"startTime"`
+	Duration  int64  `json:"durationMs"`
+}
+
+// tunCapture 一次进行中的抓包
+type tunCapture struct {
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	writer  *pcapngWriter
+	filter  captureFilter
+	maxSize int64
+	closed  bool
+	summary CaptureSummary
+	begin   time.Time
+	// 过滤在锁外进行，被排除的包数单独计数，结束时写入summary
+	skipped atomic.Uint64
+}
+
+var (
+	// 正在写入的抓包，数据包路径只读取该指针，自动停止时被清空
+	activeCapture atomic.Pointer[tunCapture]
+
+	// captureMu 保护currentCapture，串行化开始和停止
+	captureMu sync.Mutex
+	// currentCapture 最近一次开始的抓包，自动停止后仍保留到TunStopCapture取走结果
+	currentCapture *tunCapture
+)
+
+// captureTunPacket 抓取一个数据包，outbound表示从TUN读出（本机发出）的包
+func captureTunPacket(packet []byte, outbound bool) {
+	if c := activeCapture.Load(); c != nil {
+		c.write(packet, outbound)
+	}
+}
+
+// write 按过滤条件写入数据包，达到大小上限或写入失败时自动停止
+// 过滤条件创建后不再修改，在锁外匹配，锁只保护两个方向共用的文件写入
+func (c *tunCapture) write(packet []byte, outbound bool) {
+	if !c.filter.match(packet) {
+		c.skipped.Add(1)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	// EPB固定开销32字节加选项，按最大可能长度预判上限
+	if c.writer.Written()+int64(len(packet))+48 > c.maxSize {
+		c.summary.Truncated = true
+		tunLog.Warnf("抓包文件达到大小上限 %d 字节，自动停止: %s", c.maxSize, c.summary.Path)
+		c.finish()
+		return
+	}
+
+	if err := c.writer.writePacket(time.Now(), packet, outbound); err != nil {
+		c.summary.Error = err.Error()
+		tunLog.Warnf("写入抓包文件失败，自动停止: %v", err)
+		c.finish()
+		return
+	}
+	c.summary.Packets++
+}
+
+// finish 关闭文件并摘下抓包，调用者需持有c.mu
+func (c *tunCapture) finish() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	activeCapture.CompareAndSwap(c, nil)
+
+	if err := c.buf.Flush(); err != nil && c.summary.Error == "" {
+		c.summary.Error = err.Error()
+	}
+	if err := c.file.Close(); err != nil && c.summary.Error == "" {
+		c.summary.Error = err.Error()
+	}
+	c.summary.Size = c.writer.Written()
+	c.summary.Skipped = c.skipped.Load()
+	c.summary.Duration = time.Since(c.begin).Milliseconds()
+}
+
+// running 是否仍在写入
+func (c *tunCapture) running() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.closed
+}
+
+// stop 停止抓包并返回结果，已自动停止时直接返回结果
+func (c *tunCapture) stop() CaptureSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.finish()
+	return c.summary
+}
+
+// 开始抓取TUN数据包到pcapng文件
+// outputPath为空时写入日志目录；filter为空抓取全部；maxSizeMB<=0时上限100MB
+//
+//export TunStartCapture
+func TunStartCapture(cOutputPath, cFilter *C.char, maxSizeMB int32) (ret int32) {
+	defer recoverCode(&ret)
+
+	outputPath := C.GoString(cOutputPath)
+	filterText := strings.TrimSpace(C.GoString(cFilter))
+
+	filter, err := parseCaptureFilter(filterText)
+	if err != nil {
+		return setLastError(err)
+	}
+	maxSize := int64(maxSizeMB) << 20
+	if maxSizeMB <= 0 {
+		maxSize = defaultCaptureMaxSize
+	}
+
+	captureMu.Lock()
+	defer captureMu.Unlock()
+
+	if c := currentCapture; c != nil && c.running() {
+		return failf(CodeRunning, "抓包已在进行: %s", c.summary.Path)
+	}
+
+	if outputPath == "" {
+		fileLogMu.Lock()
+		sink := fileLogSink
+		fileLogMu.Unlock()
+		if sink == nil {
+			return failf(CodeInvalidState, "未指定抓包文件路径且未开启文件日志")
+		}
+		outputPath = filepath.Join(sink.Options().Dir, "mihomo-tun-"+time.Now().Format("20060102-150405")+".pcapng")
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return setLastError(wrapError(CodeCaptureIO, err, "创建抓包文件失败: %s", outputPath))
+	}
+	buf := bufio.NewWriterSize(file, 64<<10)
+
+	tunMutex.RLock()
+	ifName := tunInterface
+	tunMutex.RUnlock()
+
+	writer, err := newPcapngWriter(buf, "mihomo-core "+engineVersion(), ifName)
+	if err != nil {
+		file.Close()
+		os.Remove(outputPath)
+		return setLastError(wrapError(CodeCaptureIO, err, "写入抓包文件失败: %s", outputPath))
+	}
+
+	begin := time.Now()
+	currentCapture = &tunCapture{
+		file:    file,
+		buf:     buf,
+		writer:  writer,
+		filter:  filter,
+		maxSize: maxSize,
+		begin:   begin,
+		summary: CaptureSummary{
+			Path:      outputPath,
+			Filter:    filterText,
+			MaxSize:   maxSize,
+			StartTime: begin.Format("2006-01-02 15:04:05"),
+		},
+	}
+	activeCapture.Store(currentCapture)
+
+	tunLog.Infof("开始TUN抓包: %s (过滤: %q, 上限: %d MB)", outputPath, filterText, maxSize>>20)
+	return CodeSuccess
+}
+
+// 停止TUN抓包，返回抓包结果；抓包已自动停止时返回那次的结果
+//
+//export TunStopCapture
+func TunStopCapture() (ret *C.char) {
+	defer recoverString(&ret)
+
+	captureMu.Lock()
+	defer captureMu.Unlock()
+
+	if currentCapture == nil {
+		return configResult(nil, newError(CodeNotRunning, "没有进行中的抓包"))
+	}
+
+	summary := currentCapture.stop()
+	currentCapture = nil
+	tunLog.Infof("停止TUN抓包: %s (%d 个包, %d 字节)", summary.Path, summary.Packets, summary.Size)
+	return configResult(summary, nil)
+}
+
+// captureFilter 过滤条件，clauses之间为或，clause内的条件为与
+type captureFilter struct {
+	clauses [][]captureTerm
+}
+
+// captureTerm 单个过滤条件
+type captureTerm struct {
+	negate bool
+	match  func(info *packetInfo) bool
+}
+
+// packetInfo 过滤用到的包头字段
+type packetInfo struct {
+	version  int
+	protocol int
+	src, dst netip.Addr
+	// 非TCP/UDP或分片时hasPorts为false
+	hasPorts         bool
+	srcPort, dstPort uint16
+}
+
+func (f captureFilter) match(packet []byte) bool {
+	if len(f.clauses) == 0 {
+		return true
+	}
+	info, ok := parsePacketInfo(packet)
+	if !ok {
+		return false
+	}
+	for _, clause := range f.clauses {
+		matched := true
+		for _, term := range clause {
+			if term.match(&info) == term.negate {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCaptureFilter 解析类似BPF的过滤表达式
+// 支持: tcp udp icmp ip ip6, [src|dst] host <IP>, [src|dst] net <CIDR>, [src|dst] port <端口>,
+// 条件前可加not，条件之间用and（可省略）连接，用or分组，例如 "udp and port 53 or host 1.1.1.1"
+func parseCaptureFilter(text string) (captureFilter, error) {
+	var filter captureFilter
+	tokens := strings.Fields(strings.ToLower(text))
+	if len(tokens) == 0 {
+		return filter, nil
+	}
+
+	invalid := func(format string, args ...interface{}) (captureFilter, error) {
+		e := newError(CodeInvalidArgument, "无效的抓包过滤条件 %q: "+format, append([]interface{}{text}, args...)...)
+		e.Function = callerName(2)
+		return captureFilter{}, e
+	}
+
+	var clause []captureTerm
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token {
+		case "and", "&&":
+			continue
+		case "or", "||":
+			if len(clause) == 0 {
+				return invalid("or前缺少条件")
+			}
+			filter.clauses = append(filter.clauses, clause)
+			clause = nil
+			continue
+		}
+
+		term := captureTerm{}
+		if token == "not" || token == "!" {
+			term.negate = true
+			i++
+			if i >= len(tokens) {
+				return invalid("not后缺少条件")
+			}
+			token = tokens[i]
+		}
+
+		direction := ""
+		if token == "src" || token == "dst" {
+			direction = token
+			i++
+			if i >= len(tokens) {
+				return invalid("%s后缺少条件", direction)
+			}
+			token = tokens[i]
+		}
+
+		switch token {
+		case "tcp", "udp", "icmp", "ip", "ip6":
+			if direction != "" {
+				return invalid("%s不能用于%s", direction, token)
+			}
+			term.match = protocolMatcher(token)
+		case "host", "net", "port":
+			i++
+			if i >= len(tokens) {
+				return invalid("%s后缺少参数", token)
+			}
+			match, err := addressMatcher(token, tokens[i], direction)
+			if err != nil {
+				return invalid("%v", err)
+			}
+			term.match = match
+		default:
+			return invalid("未知的条件 %q", token)
+		}
+		clause = append(clause, term)
+	}
+
+	if len(clause) == 0 {
+		return invalid("末尾缺少条件")
+	}
+	filter.clauses = append(filter.clauses, clause)
+	return filter, nil
+}
+
+func protocolMatcher(name string) func(info *packetInfo) bool {
+	switch name {
+	case "tcp":
+		return func(info *packetInfo) bool { return info.protocol == tunProtocolTCP }
+	case "udp":
+		return func(info *packetInfo) bool { return info.protocol == tunProtocolUDP }
+	case "icmp":
+		return func(info *packetInfo) bool { return info.protocol == tunProtocolICMP }
+	case "ip":
+		return func(info *packetInfo) bool { return info.version == tunIPv4 }
+	default:
+		return func(info *packetInfo) bool { return info.version == tunIPv6 }
+	}
+}
+
+// addressMatcher 解析host/net/port条件，direction为空时源或目的任一匹配即可
+func addressMatcher(kind, value, direction string) (func(info *packetInfo) bool, error) {
+	var match func(info *packetInfo, src bool) bool
+	switch kind {
+	case "host":
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		match = func(info *packetInfo, src bool) bool {
+			if src {
+				return info.src == addr
+			}
+			return info.dst == addr
+		}
+	case "net":
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefix = prefix.Masked()
+		match = func(info *packetInfo, src bool) bool {
+			if src {
+				return prefix.Contains(info.src)
+			}
+			return prefix.Contains(info.dst)
+		}
+	default:
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		match = func(info *packetInfo, src bool) bool {
+			if !info.hasPorts {
+				return false
+			}
+			if src {
+				return info.srcPort == uint16(port)
+			}
+			return info.dstPort == uint16(port)
+		}
+	}
+
+	switch direction {
+	case "src":
+		return func(info *packetInfo) bool { return match(info, true) }, nil
+	case "dst":
+		return func(info *packetInfo) bool { return match(info, false) }, nil
+	default:
+		return func(info *packetInfo) bool { return match(info, true) || match(info, false) }, nil
+	}
+}
+
+// parsePacketInfo 解析IP头和TCP/UDP端口
+func parsePacketInfo(packet []byte) (packetInfo, bool) {
+	version, protocol, ok := classifyPacket(packet)
+	if !ok {
+		return packetInfo{}, false
+	}
+	info := packetInfo{version: version, protocol: protocol}
+
+	var payload []byte
+	if version == tunIPv4 {
+		info.src = netip.AddrFrom4([4]byte(packet[12:16]))
+		info.dst = netip.AddrFrom4([4]byte(packet[16:20]))
+		headerLen := int(packet[0]&0x0f) * 4
+		fragment := binary.BigEndian.Uint16(packet[6:8]) & 0x1fff
+		if fragment == 0 && headerLen <= len(packet) {
+			payload = packet[headerLen:]
+		}
+	} else {
+		info.src = netip.AddrFrom16([16]byte(packet[8:24]))
+		info.dst = netip.AddrFrom16([16]byte(packet[24:40]))
+		payload = packet[40:]
+	}
+
+	if (protocol == tunProtocolTCP || protocol == tunProtocolUDP) && len(payload) >= 4 {
+		info.hasPorts = true
+		info.srcPort = binary.BigEndian.Uint16(payload[0:2])
+		info.dstPort = binary.BigEndian.Uint16(payload[2:4])
+	}
+	return info, true
+}
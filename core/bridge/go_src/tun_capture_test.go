@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCaptureFilter(t *testing.T) {
+	tcp := tcpSYN(netip.MustParseAddrPort("198.18.0.2:40000"), netip.MustParseAddrPort("1.1.1.1:443"))
+	dns := udpPacket(netip.MustParseAddrPort("198.18.0.2:40001"), netip.MustParseAddrPort("8.8.8.8:53"), []byte("query"))
+
+	tests := []struct {
+		filter string
+		tcp    bool
+		dns    bool
+	}{
+		{filter: "", tcp: true, dns: true},
+		{filter: "tcp", tcp: true, dns: false},
+		{filter: "udp and port 53", tcp: false, dns: true},
+		{filter: "udp port 53", tcp: false, dns: true},
+		{filter: "ip", tcp: true, dns: true},
+		{filter: "ip6", tcp: false, dns: false},
+		{filter: "host 1.1.1.1", tcp: true, dns: false},
+		{filter: "src host 1.1.1.1", tcp: false, dns: false},
+		{filter: "dst net 8.8.0.0/16", tcp: false, dns: true},
+		{filter: "src port 40000", tcp: true, dns: false},
+		{filter: "not tcp", tcp: false, dns: true},
+		{filter: "udp and port 53 or host 1.1.1.1", tcp: true, dns: true},
+		{filter: "TCP && ! port 80", tcp: true, dns: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			filter, err := parseCaptureFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("parseCaptureFilter: %v", err)
+			}
+			if got := filter.match(tcp); got != tt.tcp {
+				t.Fatalf("TCP包匹配 = %v，期望 %v", got, tt.tcp)
+			}
+			if got := filter.match(dns); got != tt.dns {
+				t.Fatalf("DNS包匹配 = %v，期望 %v", got, tt.dns)
+			}
+		})
+	}
+
+	for _, text := range []string{"or tcp", "tcp or", "not", "src", "src tcp", "host", "host example.com", "net 10.0.0.0", "port 70000", "arp"} {
+		t.Run("无效/"+text, func(t *testing.T) {
+			if _, err := parseCaptureFilter(text); err == nil {
+				t.Fatalf("parseCaptureFilter(%q) 期望返回错误", text)
+			}
+		})
+	}
+}
+
+// TestCaptureMaxSize 达到大小上限时自动停止，文件不超过上限，被过滤的包计入skipped
+// 每轮一个TCP包和一个UDP包，触发上限的TCP包之后抓包已摘下，UDP包不再计数
+func TestCaptureMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bufio.NewWriter(file)
+	writer, err := newPcapngWriter(buf, "mihomo-core test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := parseCaptureFilter("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const maxSize = 1024
+	c := &tunCapture{file: file, buf: buf, writer: writer, filter: filter, maxSize: maxSize, begin: time.Now()}
+	activeCapture.Store(c)
+	t.Cleanup(func() { activeCapture.CompareAndSwap(c, nil) })
+
+	tcp := tcpSYN(netip.MustParseAddrPort("198.18.0.2:40000"), netip.MustParseAddrPort("1.1.1.1:443"))
+	dns := udpPacket(netip.MustParseAddrPort("198.18.0.2:40001"), netip.MustParseAddrPort("8.8.8.8:53"), []byte("query"))
+	for i := 0; i < 100 && activeCapture.Load() == c; i++ {
+		captureTunPacket(tcp, true)
+		captureTunPacket(dns, false)
+	}
+	if activeCapture.Load() != nil {
+		t.Fatal("达到大小上限后抓包没有停止")
+	}
+
+	summary := c.stop()
+	if !summary.Truncated || summary.Error != "" {
+		t.Fatalf("summary = %+v，期望因大小上限停止", summary)
+	}
+	if summary.Packets == 0 || summary.Skipped != summary.Packets {
+		t.Fatalf("packets = %d, skipped = %d", summary.Packets, summary.Skipped)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != summary.Size || info.Size() > maxSize {
+		t.Fatalf("文件大小 = %d，summary.Size = %d，上限 %d", info.Size(), summary.Size, maxSize)
+	}
+}
@@ -0,0 +1,108 @@
+// TUN设备抽象
+// 桌面Linux由核心自己创建设备（tun_linux.go），其他平台由宿主提供
+
+package main
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// tunDevice 读写原始IP数据包的TUN设备，Close会销毁设备并让阻塞中的Read返回
+type tunDevice interface {
+	Name() string
+	MTU() int
+	Read(packet []byte) (int, error)
+	Write(packet []byte) (int, error)
+	Close() error
+}
+
+// TUN接口默认参数
+const (
+	defaultTunMTU     = 1500
+	defaultTunAddress = "198.18.0.1/30"
+)
+
+// tunConfig SetTunInterface设置的接口参数，TunCreate时应用
+// Addresses每个IP版本最多一个，同时有IPv4和IPv6地址时为双栈
+type tunConfig struct {
+	Name      string
+	MTU       int
+	Addresses []netip.Prefix
+}
+
+// address 指定IP版本的接口地址
+func (c tunConfig) address(is6 bool) (netip.Prefix, bool) {
+	for _, prefix := range c.Addresses {
+		if prefix.Addr().Is6() == is6 {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// currentTunConfig 当前接口参数，由tunMutex保护
+var currentTunConfig = tunConfig{
+	MTU:       defaultTunMTU,
+	Addresses: []netip.Prefix{netip.MustParsePrefix(defaultTunAddress)},
+}
+
+// parseTunMTU 解析MTU字符串，空字符串使用默认值
+func parseTunMTU(mtu string) (int, error) {
+	mtu = strings.TrimSpace(mtu)
+	if mtu == "" {
+		return defaultTunMTU, nil
+	}
+
+	value, err := strconv.Atoi(mtu)
+	if err != nil || value < 576 || value > 65535 {
+		return 0, newError(CodeInvalidArgument, "无效的MTU: %q（有效范围576-65535）", mtu)
+	}
+	return value, nil
+}
+
+// parseTunAddresses 解析逗号分隔的接口地址，如"198.18.0.1/30,fdfe:dcba:9876::1/126"
+// 每个IP版本最多一个地址，空字符串使用默认的IPv4地址
+func parseTunAddresses(addresses string) ([]netip.Prefix, error) {
+	fields := strings.FieldsFunc(addresses, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(fields) == 0 {
+		return []netip.Prefix{netip.MustParsePrefix(defaultTunAddress)}, nil
+	}
+
+	cfg := tunConfig{}
+	for _, field := range fields {
+		prefix, err := parseTunAddress(field)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := cfg.address(prefix.Addr().Is6()); exists {
+			return nil, newError(CodeInvalidArgument, "TUN接口每个IP版本只能设置一个地址: %q", addresses)
+		}
+		cfg.Addresses = append(cfg.Addresses, prefix)
+	}
+	return cfg.Addresses, nil
+}
+
+// parseTunAddress 解析单个接口地址，支持"10.0.0.1/24"和不带前缀的"10.0.0.1"
+func parseTunAddress(address string) (netip.Prefix, error) {
+	if !strings.Contains(address, "/") {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return netip.Prefix{}, newError(CodeInvalidArgument, "无效的TUN地址: %q", address)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(address)
+	if err != nil {
+		return netip.Prefix{}, newError(CodeInvalidArgument, "无效的TUN地址: %q", address)
+	}
+	if prefix.Addr().Is4In6() {
+		return netip.Prefix{}, newError(CodeInvalidArgument, "无效的TUN地址: %q（IPv4地址请使用点分格式）", address)
+	}
+	return prefix, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestParseTunAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "默认地址", input: "", want: []string{defaultTunAddress}},
+		{name: "只有分隔符", input: " , ", want: []string{defaultTunAddress}},
+		{name: "IPv4", input: "10.0.0.1/24", want: []string{"10.0.0.1/24"}},
+		{name: "IPv4不带前缀", input: "10.0.0.1", want: []string{"10.0.0.1/32"}},
+		{name: "IPv6", input: "fdfe:dcba:9876::1/126", want: []string{"fdfe:dcba:9876::1/126"}},
+		{name: "IPv6不带前缀", input: "fd00::1", want: []string{"fd00::1/128"}},
+		{name: "映射地址不带前缀", input: "::ffff:10.0.0.1", want: []string{"10.0.0.1/32"}},
+		{name: "双栈", input: "198.18.0.1/30,fdfe:dcba:9876::1/126", want: []string{"198.18.0.1/30", "fdfe:dcba:9876::1/126"}},
+		{name: "空白分隔", input: " fdfe:dcba:9876::1/126\t198.18.0.1/30 ", want: []string{"fdfe:dcba:9876::1/126", "198.18.0.1/30"}},
+		{name: "两个IPv4", input: "10.0.0.1/24,10.0.1.1/24", wantErr: true},
+		{name: "两个IPv6", input: "fd00::1/64,fd01::1/64", wantErr: true},
+		{name: "映射地址带前缀", input: "::ffff:10.0.0.1/120", wantErr: true},
+		{name: "无效地址", input: "tun0", wantErr: true},
+		{name: "无效前缀", input: "10.0.0.1/33", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTunAddresses(tt.input)
+			if tt.wantErr {
+				var bridgeErr *BridgeError
+				if !errors.As(err, &bridgeErr) || bridgeErr.Code != CodeInvalidArgument {
+					t.Fatalf("parseTunAddresses(%q) = %v, %v，期望INVALID_ARGUMENT", tt.input, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTunAddresses(%q): %v", tt.input, err)
+			}
+			want := make([]netip.Prefix, 0, len(tt.want))
+			for _, prefix := range tt.want {
+				want = append(want, netip.MustParsePrefix(prefix))
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("parseTunAddresses(%q) = %v，期望 %v", tt.input, got, want)
+			}
+		})
+	}
+}
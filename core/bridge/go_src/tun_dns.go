@@ -0,0 +1,221 @@
+// TUN DNS劫持
+// 发往劫持目标的DNS查询不再转发到原服务器，而是由核心的DNS模块应答，
+// 使基于域名的规则、fake-ip对直接发送DNS包的应用同样生效
+// TUN没有IPv6地址时AAAA查询返回空应答，避免客户端用IPv6绕过只有IPv4的TUN
+
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	D "github.com/miekg/dns"
+)
+
+// 默认劫持所有发往53端口的DNS查询
+const defaultDNSHijack = "any:53"
+
+// dnsHijackTarget 一个劫持目标，地址为未指定地址时匹配任意目的地址
+type dnsHijackTarget struct {
+	addrPort netip.AddrPort
+	network  string // tcp、udp，空表示两者
+}
+
+// String 还原为配置形式
+func (t dnsHijackTarget) String() string {
+	target := t.addrPort.String()
+	if t.addrPort.Addr().IsUnspecified() {
+		target = "any:" + strconv.Itoa(int(t.addrPort.Port()))
+	}
+	if t.network != "" {
+		target = t.network + "://" + target
+	}
+	return target
+}
+
+// tunDNSHijack 当前劫持目标，新建连接时读取
+var tunDNSHijack atomic.Pointer[[]dnsHijackTarget]
+
+// tunDNSIPv6 TUN是否配置了IPv6地址，为false时不返回AAAA记录
+var tunDNSIPv6 atomic.Bool
+
+func init() {
+	targets, _ := parseDNSHijack(defaultDNSHijack)
+	tunDNSHijack.Store(&targets)
+	setTunDNSIPv6(currentTunConfig)
+}
+
+// setTunDNSIPv6 接口参数变化时更新是否应答AAAA
+func setTunDNSIPv6(cfg tunConfig) {
+	_, has6 := cfg.address(true)
+	tunDNSIPv6.Store(has6)
+}
+
+// parseDNSHijack 解析逗号或空白分隔的劫持目标
+// 支持"any:53"、"8.8.8.8:53"、"[::]:53"，可加"udp://"或"tcp://"前缀只劫持该协议
+func parseDNSHijack(text string) ([]dnsHijackTarget, error) {
+	targets := []dnsHijackTarget{}
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+	for _, field := range fields {
+		target := dnsHijackTarget{}
+		value := strings.ToLower(field)
+		if scheme, rest, ok := strings.Cut(value, "://"); ok {
+			if scheme != "tcp" && scheme != "udp" {
+				return nil, newError(CodeInvalidArgument, "无效的DNS劫持协议: %q", field)
+			}
+			target.network, value = scheme, rest
+		}
+		if strings.HasPrefix(value, "any:") {
+			value = "0.0.0.0:" + strings.TrimPrefix(value, "any:")
+		}
+
+		addrPort, err := netip.ParseAddrPort(value)
+		if err != nil {
+			return nil, wrapError(CodeInvalidArgument, err, "无效的DNS劫持目标: %q", field)
+		}
+		target.addrPort = netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// shouldHijackDNS 目的地址是否为劫持目标，核心未开启DNS时不劫持
+func shouldHijackDNS(network string, addr net.Addr) bool {
+	if resolver.DefaultLocalServer == nil {
+		return false
+	}
+
+	dst := netip.AddrPortFrom(addrIP(addr), addrPort(addr))
+	for _, target := range *tunDNSHijack.Load() {
+		if target.network != "" && target.network != network {
+			continue
+		}
+		if target.addrPort.Port() != dst.Port() {
+			continue
+		}
+		if target.addrPort.Addr().IsUnspecified() || target.addrPort.Addr() == dst.Addr() {
+			return true
+		}
+	}
+	return false
+}
+
+// addrPort 取出net.Addr中的端口
+func addrPort(addr net.Addr) uint16 {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return uint16(a.Port)
+	case *net.UDPAddr:
+		return uint16(a.Port)
+	}
+	return 0
+}
+
+// hijackDNSConn 应答一个TCP DNS连接上的全部查询，连接关闭或空闲超时后返回
+func hijackDNSConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	dnsLog.Debugf("劫持TCP DNS: %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
+
+	query := make([]byte, D.MaxMsgSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(resolver.DefaultDnsReadTimeout))
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, query[:length]); err != nil {
+			return
+		}
+
+		answer, err := answerDNS(ctx, query[:length], 0)
+		if err != nil {
+			dnsLog.Debugf("TCP DNS应答失败 %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		reply := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(answer)), uint16(len(answer)))
+		if _, err := conn.Write(append(reply, answer...)); err != nil {
+			return
+		}
+	}
+}
+
+// hijackDNSPacket 应答一个UDP DNS查询
+func hijackDNSPacket(ctx context.Context, query []byte, conn net.Conn) {
+	answer, err := answerDNS(ctx, query, resolver.SafeDnsPacketSize)
+	if err != nil {
+		dnsLog.Debugf("UDP DNS应答失败 %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if _, err := conn.Write(answer); err != nil {
+		dnsLog.Debugf("写回DNS应答失败 %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// answerDNS 由核心DNS模块应答一个查询，maxSize>0时按该大小截断（UDP）
+// 与resolver.RelayDnsPacket相同，另外在TUN没有IPv6地址时对AAAA查询返回空应答
+func answerDNS(ctx context.Context, query []byte, maxSize int) ([]byte, error) {
+	msg := &D.Msg{}
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+
+	if !tunDNSIPv6.Load() && len(msg.Question) > 0 && msg.Question[0].Qtype == D.TypeAAAA {
+		reply := &D.Msg{}
+		reply.SetReply(msg)
+		return reply.Pack()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolver.DefaultDnsRelayTimeout)
+	defer cancel()
+
+	reply, err := resolver.ServeMsg(ctx, msg)
+	if err != nil {
+		reply = &D.Msg{}
+		reply.SetRcode(msg, D.RcodeServerFailure)
+		return reply.Pack()
+	}
+	reply.SetRcode(msg, reply.Rcode)
+	if maxSize > 0 {
+		reply.Truncate(maxSize)
+	}
+	reply.Compress = true
+	return reply.Pack()
+}
+
+// 设置TUN模式下劫持的DNS目标，逗号分隔，如"any:53"、"udp://8.8.8.8:53,tcp://any:53"
+// 空字符串关闭劫持；默认为any:53。仅在配置开启dns时生效，新建连接时应用
+//
+//export SetTunDNSHijack
+func SetTunDNSHijack(cTargets *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
+	targets, err := parseDNSHijack(C.GoString(cTargets))
+	if err != nil {
+		return setLastError(err)
+	}
+	tunDNSHijack.Store(&targets)
+
+	dnsLog.Infof("TUN DNS劫持目标: %v", dnsHijackStrings())
+	return CodeSuccess
+}
+
+// dnsHijackStrings 当前劫持目标的配置形式
+func dnsHijackStrings() []string {
+	targets := *tunDNSHijack.Load()
+	list := make([]string, 0, len(targets))
+	for _, target := range targets {
+		list = append(list, target.String())
+	}
+	return list
+}
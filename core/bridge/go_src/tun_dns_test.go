@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	D "github.com/miekg/dns"
+)
+
+// stubDNSServer 按查询类型返回固定记录的DNS模块
+type stubDNSServer struct {
+	queries atomic.Int32
+}
+
+func (s *stubDNSServer) ServeMsg(_ context.Context, msg *D.Msg) (*D.Msg, error) {
+	s.queries.Add(1)
+	reply := &D.Msg{}
+	reply.SetReply(msg)
+	question := msg.Question[0]
+	header := D.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: D.ClassINET, Ttl: 60}
+	switch question.Qtype {
+	case D.TypeA:
+		reply.Answer = append(reply.Answer, &D.A{Hdr: header, A: net.ParseIP("198.18.0.10")})
+	case D.TypeAAAA:
+		reply.Answer = append(reply.Answer, &D.AAAA{Hdr: header, AAAA: net.ParseIP("fd00::10")})
+	}
+	return reply, nil
+}
+
+func TestAnswerDNS(t *testing.T) {
+	server := &stubDNSServer{}
+	previous := resolver.DefaultLocalServer
+	resolver.DefaultLocalServer = server
+	t.Cleanup(func() {
+		resolver.DefaultLocalServer = previous
+		setTunDNSIPv6(currentTunConfigSnapshot())
+	})
+
+	v4Only := tunConfig{Addresses: []netip.Prefix{netip.MustParsePrefix("198.18.0.1/30")}}
+	dualStack := tunConfig{Addresses: []netip.Prefix{
+		netip.MustParsePrefix("198.18.0.1/30"),
+		netip.MustParsePrefix("fdfe:dcba:9876::1/126"),
+	}}
+
+	tests := []struct {
+		name    string
+		tun     tunConfig
+		qtype   uint16
+		answers int
+		served  bool // 是否交给DNS模块
+	}{
+		{name: "只有IPv4-AAAA", tun: v4Only, qtype: D.TypeAAAA, answers: 0, served: false},
+		{name: "只有IPv4-A", tun: v4Only, qtype: D.TypeA, answers: 1, served: true},
+		{name: "双栈-AAAA", tun: dualStack, qtype: D.TypeAAAA, answers: 1, served: true},
+		{name: "双栈-A", tun: dualStack, qtype: D.TypeA, answers: 1, served: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTunDNSIPv6(tt.tun)
+			before := server.queries.Load()
+
+			query := &D.Msg{}
+			query.SetQuestion("example.com.", tt.qtype)
+			packed, err := query.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			answer, err := answerDNS(context.Background(), packed, resolver.SafeDnsPacketSize)
+			if err != nil {
+				t.Fatalf("answerDNS: %v", err)
+			}
+			reply := &D.Msg{}
+			if err := reply.Unpack(answer); err != nil {
+				t.Fatalf("应答无法解析: %v", err)
+			}
+
+			if reply.Id != query.Id || !reply.Response || reply.Rcode != D.RcodeSuccess {
+				t.Fatalf("应答头部不正确: id=%d response=%v rcode=%d", reply.Id, reply.Response, reply.Rcode)
+			}
+			if len(reply.Answer) != tt.answers {
+				t.Fatalf("应答记录数 = %d，期望 %d: %v", len(reply.Answer), tt.answers, reply.Answer)
+			}
+			for _, rr := range reply.Answer {
+				if rr.Header().Rrtype != tt.qtype {
+					t.Fatalf("应答记录类型 = %d，期望 %d", rr.Header().Rrtype, tt.qtype)
+				}
+			}
+			if served := server.queries.Load() != before; served != tt.served {
+				t.Fatalf("查询交给DNS模块 = %v，期望 %v", served, tt.served)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+//go:build !windows
+
+// 宿主提供的TUN文件描述符
+// Android的VpnService.establish()、iOS的utun等由宿主创建设备，fd交给核心后由核心负责读写和关闭
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// darwin/ios的utun每个包前有4字节的协议族头
+const utunHeaderLen = 4
+
+const hasUtunHeader = runtime.GOOS == "darwin" || runtime.GOOS == "ios"
+
+// fdTun 宿主创建的TUN设备
+type fdTun struct {
+	file *os.File
+	name string
+	mtu  int
+
+	readMu   sync.Mutex
+	readBuf  []byte
+	writeMu  sync.Mutex
+	writeBuf []byte
+}
+
+func (t *fdTun) Name() string { return t.name }
+func (t *fdTun) MTU() int     { return t.mtu }
+
+func (t *fdTun) Read(packet []byte) (int, error) {
+	if !hasUtunHeader {
+		return t.file.Read(packet)
+	}
+
+	t.readMu.Lock()
+	defer t.readMu.Unlock()
+
+	n, err := t.file.Read(t.readBuf)
+	if err != nil {
+		return 0, err
+	}
+	if n < utunHeaderLen {
+		return 0, nil
+	}
+	return copy(packet, t.readBuf[utunHeaderLen:n]), nil
+}
+
+func (t *fdTun) Write(packet []byte) (int, error) {
+	if !hasUtunHeader {
+		return t.file.Write(packet)
+	}
+	if len(packet) == 0 {
+		return 0, nil
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	family := uint32(unix.AF_INET)
+	if packet[0]>>4 == 6 {
+		family = unix.AF_INET6
+	}
+	t.writeBuf = append(t.writeBuf[:0], 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(t.writeBuf, family)
+	t.writeBuf = append(t.writeBuf, packet...)
+
+	n, err := t.file.Write(t.writeBuf)
+	if n >= utunHeaderLen {
+		n -= utunHeaderLen
+	} else {
+		n = 0
+	}
+	return n, err
+}
+
+// Close 关闭fd，宿主不能再使用该fd
+func (t *fdTun) Close() error {
+	return t.file.Close()
+}
+
+// attachTunDevice 接管宿主传入的TUN fd
+func attachTunDevice(fd int, mtu int) (tunDevice, error) {
+	if err := unix.SetNonblock(fd, true); err != nil {
+		return nil, wrapError(CodeTunDevice, err, "无效的TUN文件描述符: %d", fd)
+	}
+
+	name := fmt.Sprintf("fd%d", fd)
+	device := &fdTun{
+		file: os.NewFile(uintptr(fd), name),
+		name: name,
+		mtu:  mtu,
+	}
+	if hasUtunHeader {
+		device.readBuf = make([]byte, mtu+utunHeaderLen)
+	}
+	return device, nil
+}
@@ -0,0 +1,8 @@
+// Windows没有可接管的TUN文件描述符，需使用wintun等由宿主处理
+
+package main
+
+// attachTunDevice Windows不支持接管TUN fd
+func attachTunDevice(fd int, mtu int) (tunDevice, error) {
+	return nil, newError(CodeTunUnsupported, "Windows不支持接管TUN文件描述符")
+}
@@ -0,0 +1,255 @@
+//go:build linux && !android
+
+// Linux桌面端TUN设备
+// 通过/dev/net/tun创建接口，用ioctl设置MTU、IPv4/IPv6地址并启用，关闭fd即销毁接口
+// 内核为每个地址添加对应网段的路由，双栈时两个IP版本的流量都进入TUN
+
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const tunCloneDevice = "/dev/net/tun"
+
+// linuxTun 核心创建的TUN接口
+type linuxTun struct {
+	file  *os.File
+	name  string
+	mtu   int
+	addr4 netip.Prefix // 已设置的地址，重新配置时据此替换
+	addr6 netip.Prefix
+}
+
+func (t *linuxTun) Name() string { return t.name }
+func (t *linuxTun) MTU() int     { return t.mtu }
+
+func (t *linuxTun) Read(packet []byte) (int, error) {
+	return t.file.Read(packet)
+}
+
+func (t *linuxTun) Write(packet []byte) (int, error) {
+	return t.file.Write(packet)
+}
+
+// Close 关闭fd，非持久化接口随之被内核删除
+func (t *linuxTun) Close() error {
+	return t.file.Close()
+}
+
+// createTunDevice 创建并启用TUN接口
+func createTunDevice(cfg tunConfig) (tunDevice, error) {
+	fd, err := unix.Open(tunCloneDevice, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, tunSyscallError(err, "打开%s失败", tunCloneDevice)
+	}
+
+	ifr, err := unix.NewIfreq(cfg.Name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, newError(CodeInvalidArgument, "无效的TUN接口名: %q", cfg.Name)
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, tunSyscallError(err, "创建TUN接口%q失败", cfg.Name)
+	}
+
+	// 非阻塞fd交给运行时的netpoller，Close时阻塞中的Read会立即返回
+	device := &linuxTun{
+		file: os.NewFile(uintptr(fd), tunCloneDevice),
+		name: ifr.Name(),
+	}
+
+	if err := configureTunDevice(device, cfg); err != nil {
+		device.Close()
+		return nil, err
+	}
+	return device, nil
+}
+
+// configureTunDevice 设置MTU和地址并启用接口，接口已存在时也可重复调用
+func configureTunDevice(device tunDevice, cfg tunConfig) error {
+	t, ok := device.(*linuxTun)
+	if !ok {
+		return nil
+	}
+
+	sock, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return tunSyscallError(err, "创建配置socket失败")
+	}
+	defer unix.Close(sock)
+
+	if err := setTunMTU(sock, t.name, cfg.MTU); err != nil {
+		return err
+	}
+	t.mtu = cfg.MTU
+
+	prefix4, _ := cfg.address(false)
+	if err := t.setAddress4(sock, prefix4); err != nil {
+		return err
+	}
+	prefix6, _ := cfg.address(true)
+	if err := t.setAddress6(prefix6); err != nil {
+		return err
+	}
+	return setTunUp(sock, t.name)
+}
+
+func setTunMTU(sock int, name string, mtu int) error {
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return newError(CodeInvalidArgument, "无效的TUN接口名: %q", name)
+	}
+	ifr.SetUint32(uint32(mtu))
+	if err := unix.IoctlIfreq(sock, unix.SIOCSIFMTU, ifr); err != nil {
+		return tunSyscallError(err, "设置%s的MTU为%d失败", name, mtu)
+	}
+	return nil
+}
+
+// setAddress4 设置IPv4地址和掩码，prefix无效时清除之前设置的地址
+func (t *linuxTun) setAddress4(sock int, prefix netip.Prefix) error {
+	if !prefix.IsValid() {
+		if !t.addr4.IsValid() {
+			return nil
+		}
+		ifr, _ := unix.NewIfreq(t.name)
+		ifr.SetInet4Addr(make([]byte, 4))
+		if err := unix.IoctlIfreq(sock, unix.SIOCSIFADDR, ifr); err != nil {
+			return tunSyscallError(err, "清除%s的IPv4地址失败", t.name)
+		}
+		t.addr4 = netip.Prefix{}
+		return nil
+	}
+
+	ifr, err := unix.NewIfreq(t.name)
+	if err != nil {
+		return newError(CodeInvalidArgument, "无效的TUN接口名: %q", t.name)
+	}
+	addr := prefix.Addr().As4()
+	if err := ifr.SetInet4Addr(addr[:]); err != nil {
+		return wrapError(CodeInvalidArgument, err, "无效的TUN地址: %s", prefix)
+	}
+	if err := unix.IoctlIfreq(sock, unix.SIOCSIFADDR, ifr); err != nil {
+		return tunSyscallError(err, "设置%s的地址为%s失败", t.name, prefix)
+	}
+
+	ifr, _ = unix.NewIfreq(t.name)
+	mask := prefixMask4(prefix.Bits())
+	if err := ifr.SetInet4Addr(mask[:]); err != nil {
+		return wrapError(CodeInvalidArgument, err, "无效的TUN掩码: %s", prefix)
+	}
+	if err := unix.IoctlIfreq(sock, unix.SIOCSIFNETMASK, ifr); err != nil {
+		return tunSyscallError(err, "设置%s的掩码失败", t.name)
+	}
+	t.addr4 = prefix
+	return nil
+}
+
+// setAddress6 设置IPv6地址，替换之前设置的地址，prefix无效时只删除
+// TUN接口不做重复地址检测，地址设置后立即可用
+func (t *linuxTun) setAddress6(prefix netip.Prefix) error {
+	if prefix == t.addr6 {
+		return nil
+	}
+	if t.addr6.IsValid() {
+		err := ioctlAddress6(t.name, t.addr6, unix.SIOCDIFADDR)
+		if err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+			return tunSyscallError(err, "删除%s的地址%s失败", t.name, t.addr6)
+		}
+		t.addr6 = netip.Prefix{}
+	}
+	if !prefix.IsValid() {
+		return nil
+	}
+
+	err := ioctlAddress6(t.name, prefix, unix.SIOCSIFADDR)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return tunSyscallError(err, "设置%s的地址为%s失败", t.name, prefix)
+	}
+	t.addr6 = prefix
+	return nil
+}
+
+// in6Ifreq 内核的struct in6_ifreq
+type in6Ifreq struct {
+	addr      [16]byte
+	prefixLen uint32
+	ifindex   int32
+}
+
+// ioctlAddress6 在AF_INET6 socket上增删接口的IPv6地址，系统未启用IPv6时返回EAFNOSUPPORT
+func ioctlAddress6(name string, prefix netip.Prefix, request uint) error {
+	sock, err := unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(sock)
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(sock, unix.SIOCGIFINDEX, ifr); err != nil {
+		return err
+	}
+
+	req := in6Ifreq{
+		addr:      prefix.Addr().As16(),
+		prefixLen: uint32(prefix.Bits()),
+		ifindex:   int32(ifr.Uint32()),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(sock), uintptr(request), uintptr(unsafe.Pointer(&req)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setTunUp 启用接口
+func setTunUp(sock int, name string) error {
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return newError(CodeInvalidArgument, "无效的TUN接口名: %q", name)
+	}
+	if err := unix.IoctlIfreq(sock, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return tunSyscallError(err, "读取%s的状态失败", name)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP | unix.IFF_RUNNING)
+	if err := unix.IoctlIfreq(sock, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return tunSyscallError(err, "启用%s失败", name)
+	}
+	return nil
+}
+
+// prefixMask4 前缀长度转换为IPv4掩码
+func prefixMask4(bits int) [4]byte {
+	var mask [4]byte
+	for i := 0; i < bits && i < 32; i++ {
+		mask[i/8] |= 0x80 >> (i % 8)
+	}
+	return mask
+}
+
+// tunSyscallError 把系统调用错误映射为错误码，权限不足时提示CAP_NET_ADMIN
+func tunSyscallError(err error, format string, args ...interface{}) error {
+	var e *BridgeError
+	switch {
+	case errors.Is(err, unix.EPERM), errors.Is(err, unix.EACCES):
+		e = wrapError(CodeTunPermission, err, format, args...)
+		e.Message += "（需要CAP_NET_ADMIN权限，请以root运行或执行 setcap cap_net_admin+ep）"
+	case errors.Is(err, unix.ENOENT), errors.Is(err, unix.ENODEV):
+		e = wrapError(CodeTunUnsupported, err, format, args...)
+	default:
+		e = wrapError(CodeTunDevice, err, format, args...)
+	}
+	e.Function = callerName(2)
+	return e
+}
@@ -0,0 +1,16 @@
+//go:build !linux || android
+
+// 非Linux桌面平台的TUN设备
+// Android/iOS/macOS/Windows由宿主（VpnService、NetworkExtension等）创建设备，核心不能自行创建
+
+package main
+
+// createTunDevice 当前平台不支持由核心创建TUN接口
+func createTunDevice(cfg tunConfig) (tunDevice, error) {
+	return nil, newError(CodeTunUnsupported, "当前平台不支持创建TUN接口%q，需由宿主创建设备", cfg.Name)
+}
+
+// configureTunDevice 宿主创建的设备由宿主负责配置
+func configureTunDevice(device tunDevice, cfg tunConfig) error {
+	return nil
+}
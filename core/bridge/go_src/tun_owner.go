@@ -0,0 +1,106 @@
+// TUN连接所属应用
+// 分应用代理按连接所属应用的UID和包名匹配：
+//   - Linux：通过sock_diag按连接的四元组查到本机套接字的UID，不扫描/proc，也不需要进程路径
+//   - Android：应用无权查询其他应用的套接字，由宿主通过ConnectivityManager.getConnectionOwnerUid
+//     查询UID、PackageManager.getPackagesForUid查询包名，经SetConnectionOwnerResolver提供给核心
+// 只有确实找不到套接字（如转发自其他设备）时才视为找不到所属应用
+
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/metacubex/gvisor/pkg/tcpip/transport/tcp"
+	"github.com/metacubex/gvisor/pkg/tcpip/transport/udp"
+)
+
+// ConnectionOwnerResolver 移动端连接归属查询接口，gomobile会生成对应的Java/ObjC接口
+// 在核心的连接处理goroutine上并发调用，需要线程安全且尽快返回
+type ConnectionOwnerResolver interface {
+	// FindConnectionOwner 返回连接所属应用的UID，找不到时返回-1
+	// protocol为6(TCP)或17(UDP)，source为应用一侧的地址，destination为目的地址，格式为"ip:port"
+	FindConnectionOwner(protocol int32, source string, destination string) int32
+	// PackagesForUid 返回UID对应的包名，多个应用共享UID时以逗号分隔
+	PackagesForUid(uid int32) string
+}
+
+// tunAppOwner 连接所属应用
+type tunAppOwner struct {
+	uid      uint32
+	packages []string // 只在分应用列表包含包名时查询
+}
+
+var (
+	ownerMu       sync.RWMutex
+	ownerResolver ConnectionOwnerResolver
+)
+
+// hostOwnerAvailable 宿主是否提供了连接归属查询
+func hostOwnerAvailable() bool {
+	ownerMu.RLock()
+	defer ownerMu.RUnlock()
+	return ownerResolver != nil
+}
+
+// findTunAppOwner 查找连接所属应用，found为false表示找不到所属应用
+// Android由宿主查询，其他平台查询本机套接字；needPackages为true时同时查询包名
+func findTunAppOwner(network string, source, destination netip.AddrPort, needPackages bool) (tunAppOwner, bool) {
+	if tunAppPackageSupported {
+		return hostAppOwner(network, source, destination, needPackages)
+	}
+
+	uid, err := socketOwnerUID(network, source, destination)
+	if err != nil {
+		tunLog.Debugf("查找TUN连接 %s 所属应用失败: %v", source, err)
+		return tunAppOwner{}, false
+	}
+	return tunAppOwner{uid: uid}, true
+}
+
+// hostAppOwner 由宿主查询连接所属应用，宿主接口中的panic按找不到所属应用处理
+func hostAppOwner(network string, source, destination netip.AddrPort, needPackages bool) (owner tunAppOwner, found bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			tunLog.Errorf("宿主查询TUN连接 %s 所属应用异常: %v", source, r)
+			owner, found = tunAppOwner{}, false
+		}
+	}()
+
+	ownerMu.RLock()
+	resolver := ownerResolver
+	ownerMu.RUnlock()
+	if resolver == nil {
+		tunLog.Debugf("宿主未提供连接归属查询，无法判断TUN连接 %s 所属应用", source)
+		return owner, false
+	}
+
+	protocol := int32(udp.ProtocolNumber)
+	if network == "tcp" {
+		protocol = int32(tcp.ProtocolNumber)
+	}
+
+	uid := resolver.FindConnectionOwner(protocol, source.String(), destination.String())
+	if uid < 0 {
+		return owner, false
+	}
+
+	owner.uid = uint32(uid)
+	if !needPackages {
+		return owner, true
+	}
+	for _, name := range strings.Split(resolver.PackagesForUid(uid), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			owner.packages = append(owner.packages, name)
+		}
+	}
+	return owner, true
+}
+
+// SetConnectionOwnerResolver 注册移动端连接归属查询，传nil注销，之后新建的连接按找不到所属应用处理
+func SetConnectionOwnerResolver(resolver ConnectionOwnerResolver) {
+	ownerMu.Lock()
+	ownerResolver = resolver
+	ownerMu.Unlock()
+}
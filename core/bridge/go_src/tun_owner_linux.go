@@ -0,0 +1,135 @@
+//go:build linux && !android
+
+// Linux连接所属应用
+// 通过sock_diag（NETLINK_SOCK_DIAG）按端口列出本机套接字，再按四元组找到TUN连接对应的套接字，
+// 相当于 ss -tuen sport = :<port>；只需要套接字的UID，不扫描/proc，所有连接复用同一个netlink套接字
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sync"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+// inet_diag_req_v2与inet_diag_msg的大小
+const (
+	inetDiagRequestSize  = 56
+	inetDiagResponseSize = 72
+)
+
+// inet_diag_msg中UID的偏移：family state timer retrans(4) + sockid(48) + expires rqueue wqueue(12)
+const inetDiagUIDOffset = 64
+
+var errSocketNotFound = errors.New("本机没有对应的套接字")
+
+// 复用的sock_diag连接，查询出错后关闭，下次查询时重新建立
+var (
+	sockDiagMu   sync.Mutex
+	sockDiagConn *netlink.Conn
+)
+
+// socketOwnerUID 查找TUN连接在本机对应的套接字的UID，source为应用一侧的地址
+// 各连接处理goroutine串行使用同一个sock_diag连接
+func socketOwnerUID(network string, source, destination netip.AddrPort) (uint32, error) {
+	protocol := uint8(unix.IPPROTO_UDP)
+	if network == "tcp" {
+		protocol = unix.IPPROTO_TCP
+	}
+
+	sockDiagMu.Lock()
+	defer sockDiagMu.Unlock()
+
+	if sockDiagConn == nil {
+		conn, err := netlink.Dial(unix.NETLINK_SOCK_DIAG, nil)
+		if err != nil {
+			return 0, err
+		}
+		sockDiagConn = conn
+	}
+
+	uid, err := lookupSocketOwner(sockDiagConn, protocol, source, destination)
+	if err != nil && !errors.Is(err, errSocketNotFound) {
+		sockDiagConn.Close()
+		sockDiagConn = nil
+	}
+	return uid, err
+}
+
+// lookupSocketOwner 先在连接的地址族中查找，IPv4连接也可能来自IPv6双栈套接字，再按映射地址查一次
+func lookupSocketOwner(conn *netlink.Conn, protocol uint8, source, destination netip.AddrPort) (uint32, error) {
+	if source.Addr().Is4() {
+		uid, err := querySocketOwner(conn, unix.AF_INET, protocol, source, destination)
+		if !errors.Is(err, errSocketNotFound) {
+			return uid, err
+		}
+		source = netip.AddrPortFrom(netip.AddrFrom16(source.Addr().As16()), source.Port())
+		destination = netip.AddrPortFrom(netip.AddrFrom16(destination.Addr().As16()), destination.Port())
+	}
+	return querySocketOwner(conn, unix.AF_INET6, protocol, source, destination)
+}
+
+// querySocketOwner 列出一个地址族中源端口相同的套接字，返回与连接四元组一致的套接字的UID
+// 未连接的UDP套接字目的地址为空，未绑定地址的套接字源地址为空，均视为一致
+func querySocketOwner(conn *netlink.Conn, family, protocol uint8, source, destination netip.AddrPort) (uint32, error) {
+	// inet_diag_req_v2: family protocol ext pad states sockid(sport dport src[16] dst[16] if cookie[2])
+	request := make([]byte, inetDiagRequestSize)
+	request[0] = family
+	request[1] = protocol
+	copy(request[4:], nlenc.Uint32Bytes(0xffffffff))
+	binary.BigEndian.PutUint16(request[8:], source.Port())
+	if protocol == unix.IPPROTO_TCP {
+		binary.BigEndian.PutUint16(request[10:], destination.Port())
+	}
+	// INET_DIAG_NOCOOKIE，不按cookie查找
+	copy(request[48:], nlenc.Uint32Bytes(0xffffffff))
+	copy(request[52:], nlenc.Uint32Bytes(0xffffffff))
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{Type: unix.SOCK_DIAG_BY_FAMILY, Flags: netlink.Request | netlink.Dump},
+		Data:   request,
+	})
+	if err != nil {
+		// 内核未启用IPv6时没有对应的地址族
+		if family == unix.AF_INET6 && errors.Is(err, unix.EAFNOSUPPORT) {
+			return 0, errSocketNotFound
+		}
+		return 0, err
+	}
+
+	for _, msg := range msgs {
+		if len(msg.Data) < inetDiagResponseSize {
+			continue
+		}
+		// sockid: sport dport src[16] dst[16] if cookie[2]
+		id := msg.Data[4:]
+		if binary.BigEndian.Uint16(id[0:]) != source.Port() {
+			continue
+		}
+		if !socketAddrMatches(family, id[4:20], source.Addr()) {
+			continue
+		}
+		dport := binary.BigEndian.Uint16(id[2:])
+		if dport != 0 && (dport != destination.Port() || !socketAddrMatches(family, id[20:36], destination.Addr())) {
+			continue
+		}
+		return nlenc.Uint32(msg.Data[inetDiagUIDOffset : inetDiagUIDOffset+4]), nil
+	}
+	return 0, errSocketNotFound
+}
+
+// socketAddrMatches 套接字地址为空或与addr相同，IPv4地址只占前4字节
+func socketAddrMatches(family uint8, raw []byte, addr netip.Addr) bool {
+	var socketAddr netip.Addr
+	if family == unix.AF_INET {
+		socketAddr = netip.AddrFrom4([4]byte(raw[:4]))
+	} else {
+		socketAddr = netip.AddrFrom16([16]byte(raw[:16]))
+	}
+	return socketAddr.IsUnspecified() || socketAddr == addr
+}
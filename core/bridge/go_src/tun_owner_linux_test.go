@@ -0,0 +1,105 @@
+//go:build linux && !android
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+)
+
+func TestSocketOwnerUID(t *testing.T) {
+	uid := uint32(os.Getuid())
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// 双栈监听套接字接受的IPv4连接在AF_INET6中以映射地址出现
+	listener6, err := net.Listen("tcp", "[::]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener6.Close()
+	client4, err := net.DialTCP("tcp4", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: listener6.Addr().(*net.TCPAddr).Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client4.Close()
+	server6, err := listener6.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server6.Close()
+
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	addrPort := func(addr net.Addr) netip.AddrPort {
+		return netip.AddrPortFrom(addrIP(addr), addrPort(addr))
+	}
+	unused := netip.MustParseAddrPort("127.0.0.1:1")
+
+	tests := []struct {
+		name        string
+		network     string
+		source      netip.AddrPort
+		destination netip.AddrPort
+		found       bool
+	}{
+		{name: "TCP", network: "tcp", source: addrPort(client.LocalAddr()), destination: addrPort(client.RemoteAddr()), found: true},
+		{name: "TCP双栈套接字", network: "tcp", source: addrPort(server6.LocalAddr()), destination: addrPort(server6.RemoteAddr()), found: true},
+		{name: "TCP目的地址不一致", network: "tcp", source: addrPort(client.LocalAddr()), destination: unused, found: false},
+		{name: "未连接的UDP", network: "udp", source: addrPort(udp.LocalAddr()), destination: unused, found: true},
+		{name: "UDP源地址不一致", network: "udp", source: netip.AddrPortFrom(netip.MustParseAddr("127.0.0.2"), addrPort(udp.LocalAddr()).Port()), destination: unused, found: false},
+	}
+
+	sockDiagMu.Lock()
+	if sockDiagConn != nil {
+		sockDiagConn.Close()
+		sockDiagConn = nil
+	}
+	sockDiagMu.Unlock()
+
+	var conn *netlink.Conn
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := socketOwnerUID(tt.network, tt.source, tt.destination)
+			if !tt.found {
+				if !errors.Is(err, errSocketNotFound) {
+					t.Fatalf("socketOwnerUID = %d, %v，期望找不到套接字", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("socketOwnerUID: %v", err)
+			}
+			if got != uid {
+				t.Fatalf("socketOwnerUID = %d，期望 %d", got, uid)
+			}
+		})
+
+		// 找不到套接字不影响复用，所有查询使用同一个sock_diag连接
+		sockDiagMu.Lock()
+		current := sockDiagConn
+		sockDiagMu.Unlock()
+		if current == nil || (conn != nil && current != conn) {
+			t.Fatalf("%s: sock_diag连接没有复用", tt.name)
+		}
+		conn = current
+	}
+}
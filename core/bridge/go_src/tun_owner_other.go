@@ -0,0 +1,13 @@
+//go:build !linux || android
+
+// 非Linux桌面平台的连接所属应用
+// Android由宿主查询（见tun_owner.go），其他平台不支持按UID分应用代理
+
+package main
+
+import "net/netip"
+
+// socketOwnerUID 当前平台不能查询本机套接字
+func socketOwnerUID(network string, source, destination netip.AddrPort) (uint32, error) {
+	return 0, newError(CodeTunUnsupported, "当前平台不支持查找连接所属应用")
+}
@@ -0,0 +1,219 @@
+// TUN数据包二进制接口
+// 宿主通过指针+长度收发原始IP包，缓冲区由调用者提供，支持批量收发减少跨语言调用次数
+// 只适用于TunAttachPacketFlow创建的宿主队列设备；TunCreate/TunAttachFd的设备由核心直接读写
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"os"
+	"time"
+	"unsafe"
+)
+
+// hostQueue 获取当前的宿主队列设备
+func hostQueue() (*queueTun, error) {
+	tunMutex.RLock()
+	defer tunMutex.RUnlock()
+
+	if !tunActive {
+		return nil, newError(CodeTunNotCreated, "TUN接口未创建")
+	}
+	queue, ok := tunDev.(*queueTun)
+	if !ok {
+		return nil, newError(CodeInvalidState, "TUN设备%s由核心直接读写，不支持宿主收发数据包", tunInterface)
+	}
+	return queue, nil
+}
+
+// queueError 设备在调用期间被关闭时转换为TUN未创建
+func queueError(err error) error {
+	if errors.Is(err, os.ErrClosed) {
+		return newError(CodeTunNotCreated, "TUN接口已关闭")
+	}
+	return err
+}
+
+// timeoutDuration 毫秒超时转换，<0表示一直等待
+func timeoutDuration(timeoutMs int32) time.Duration {
+	if timeoutMs < 0 {
+		return -1
+	}
+	return time.Duration(timeoutMs) * time.Millisecond
+}
+
+// 创建宿主队列TUN设备，用于iOS NEPacketTunnelFlow等没有fd的场景
+//
+//export TunAttachPacketFlow
+func TunAttachPacketFlow(mtu int32) (ret int32) {
+	defer recoverCode(&ret)
+
+	if mtu <= 0 {
+		mtu = defaultTunMTU
+	}
+	if mtu < 576 || mtu > 65535 {
+		return failf(CodeInvalidArgument, "无效的MTU: %d（有效范围576-65535）", mtu)
+	}
+
+	tunMutex.Lock()
+	defer tunMutex.Unlock()
+
+	if err := checkTunIdle(); err != nil {
+		return setLastError(err)
+	}
+
+	installTunDevice(newQueueTun(int(mtu)))
+	tunLog.Infof("创建宿主队列TUN设备 (MTU: %d)", mtu)
+	return CodeSuccess
+}
+
+// 宿主送入一个从系统读到的IP包，数据在返回前已复制
+//
+//export TunWritePacketBytes
+func TunWritePacketBytes(data *C.uint8_t, length int32) (ret int32) {
+	defer recoverCode(&ret)
+
+	queue, err := hostQueue()
+	if err != nil {
+		return setLastError(err)
+	}
+	if data == nil || length <= 0 || int(length) > queue.MTU() {
+		return failf(CodeInvalidArgument, "无效的数据包长度: %d（MTU: %d）", length, queue.MTU())
+	}
+
+	accepted, err := queue.push(unsafe.Slice((*byte)(unsafe.Pointer(data)), int(length)))
+	if err != nil {
+		return setLastError(queueError(err))
+	}
+	if !accepted {
+		return failf(CodeBusy, "TUN入站队列已满，数据包被丢弃")
+	}
+	return CodeSuccess
+}
+
+// 宿主批量送入IP包，包在buffer中连续存放，第i个包长度为sizes[i]
+// 返回被接受的包数，队列满时后续包被丢弃；<0为负的错误码
+//
+//export TunWritePackets
+func TunWritePackets(buffer *C.uint8_t, sizes *C.int32_t, count int32) (ret int32) {
+	defer recoverCode(&ret)
+
+	queue, err := hostQueue()
+	if err != nil {
+		return -setLastError(err)
+	}
+	if count <= 0 {
+		return 0
+	}
+	if buffer == nil || sizes == nil {
+		return -failf(CodeInvalidArgument, "数据包缓冲区不能为空")
+	}
+
+	lengths := unsafe.Slice((*int32)(unsafe.Pointer(sizes)), int(count))
+	total := 0
+	for _, length := range lengths {
+		if length <= 0 || int(length) > queue.MTU() {
+			return -failf(CodeInvalidArgument, "无效的数据包长度: %d（MTU: %d）", length, queue.MTU())
+		}
+		total += int(length)
+	}
+
+	data := unsafe.Slice((*byte)(unsafe.Pointer(buffer)), total)
+	var accepted int32
+	for _, length := range lengths {
+		ok, err := queue.push(data[:length])
+		if err != nil {
+			return -setLastError(queueError(err))
+		}
+		if !ok {
+			break
+		}
+		accepted++
+		data = data[length:]
+	}
+	return accepted
+}
+
+// 宿主读取一个需要写入系统的IP包到buffer
+// 返回包长度，0表示超时内没有数据包；<0为负的错误码
+//
+//export TunReadPacketBytes
+func TunReadPacketBytes(buffer *C.uint8_t, bufferSize, timeoutMs int32) (ret int32) {
+	defer recoverCode(&ret)
+
+	queue, err := hostQueue()
+	if err != nil {
+		return -setLastError(err)
+	}
+	if buffer == nil || int(bufferSize) < queue.MTU() {
+		return -failf(CodeInvalidArgument, "缓冲区不能小于MTU: %d < %d", bufferSize, queue.MTU())
+	}
+
+	packet, err := queue.pull(timeoutDuration(timeoutMs))
+	if err != nil {
+		return -setLastError(queueError(err))
+	}
+	if packet == nil {
+		return 0
+	}
+
+	n := copy(unsafe.Slice((*byte)(unsafe.Pointer(buffer)), int(bufferSize)), packet)
+	queue.release(packet)
+	return int32(n)
+}
+
+// 宿主批量读取IP包，包连续写入buffer，第i个包长度写入sizes[i]
+// 只有第一个包会等待timeoutMs，之后取完已就绪的包立即返回
+// 返回读到的包数；<0为负的错误码
+//
+//export TunReadPackets
+func TunReadPackets(buffer *C.uint8_t, bufferSize int32, sizes *C.int32_t, maxPackets, timeoutMs int32) (ret int32) {
+	defer recoverCode(&ret)
+
+	queue, err := hostQueue()
+	if err != nil {
+		return -setLastError(err)
+	}
+	if buffer == nil || sizes == nil || maxPackets <= 0 {
+		return -failf(CodeInvalidArgument, "数据包缓冲区不能为空")
+	}
+	if int(bufferSize) < queue.MTU() {
+		return -failf(CodeInvalidArgument, "缓冲区不能小于MTU: %d < %d", bufferSize, queue.MTU())
+	}
+
+	data := unsafe.Slice((*byte)(unsafe.Pointer(buffer)), int(bufferSize))
+	lengths := unsafe.Slice((*int32)(unsafe.Pointer(sizes)), int(maxPackets))
+
+	var count int32
+	timeout := timeoutDuration(timeoutMs)
+	for count < maxPackets {
+		packet, err := queue.pull(timeout)
+		if err != nil {
+			if count > 0 {
+				break
+			}
+			return -setLastError(queueError(err))
+		}
+		if packet == nil {
+			break
+		}
+		if len(packet) > len(data) {
+			queue.unpull(packet)
+			break
+		}
+
+		copy(data, packet)
+		lengths[count] = int32(len(packet))
+		data = data[len(packet):]
+		queue.release(packet)
+
+		count++
+		timeout = 0
+	}
+	return count
+}
@@ -0,0 +1,152 @@
+// 宿主队列TUN设备
+// iOS的NEPacketTunnelFlow等只提供读写包的接口而没有fd，数据包由宿主通过TunWritePackets送入、TunReadPackets取出
+
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// 宿主队列容量（包数），写满后新包被丢弃，与网卡队列满时的行为一致
+const tunQueueSize = 1024
+
+// queueTun 宿主驱动的TUN设备
+// 入站: 宿主 → inbound → Read（协议栈）; 出站: Write（协议栈） → outbound → 宿主
+type queueTun struct {
+	mtu      int
+	inbound  chan []byte
+	outbound chan []byte
+	closed   chan struct{}
+	once     sync.Once
+	buffers  sync.Pool
+
+	// 宿主读取时放不进调用者缓冲区的包，留到下次返回
+	pendingMu sync.Mutex
+	pending   []byte
+}
+
+func newQueueTun(mtu int) *queueTun {
+	t := &queueTun{
+		mtu:      mtu,
+		inbound:  make(chan []byte, tunQueueSize),
+		outbound: make(chan []byte, tunQueueSize),
+		closed:   make(chan struct{}),
+	}
+	t.buffers.New = func() interface{} {
+		return make([]byte, 0, mtu)
+	}
+	return t
+}
+
+func (t *queueTun) Name() string { return "flow" }
+func (t *queueTun) MTU() int     { return t.mtu }
+
+// Read 协议栈读取宿主送入的包，设备关闭后返回os.ErrClosed
+func (t *queueTun) Read(packet []byte) (int, error) {
+	select {
+	case buf := <-t.inbound:
+		n := copy(packet, buf)
+		t.release(buf)
+		return n, nil
+	case <-t.closed:
+		return 0, os.ErrClosed
+	}
+}
+
+// Write 协议栈发出的包进入出站队列，宿主读取不及时则丢弃
+func (t *queueTun) Write(packet []byte) (int, error) {
+	select {
+	case <-t.closed:
+		return 0, os.ErrClosed
+	default:
+	}
+
+	buf := append(t.acquire(), packet...)
+	select {
+	case t.outbound <- buf:
+	default:
+		t.release(buf)
+	}
+	return len(packet), nil
+}
+
+func (t *queueTun) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func (t *queueTun) acquire() []byte {
+	return t.buffers.Get().([]byte)[:0]
+}
+
+func (t *queueTun) release(buf []byte) {
+	if cap(buf) >= t.mtu {
+		t.buffers.Put(buf[:0])
+	}
+}
+
+// push 宿主送入一个包，队列满时返回false
+func (t *queueTun) push(packet []byte) (bool, error) {
+	select {
+	case <-t.closed:
+		return false, os.ErrClosed
+	default:
+	}
+
+	buf := append(t.acquire(), packet...)
+	select {
+	case t.inbound <- buf:
+		return true, nil
+	default:
+		t.release(buf)
+		return false, nil
+	}
+}
+
+// pull 宿主取出一个包，timeout<0一直等待，0不等待；无包时返回nil
+// 取出的包用完后需调用release归还
+func (t *queueTun) pull(timeout time.Duration) ([]byte, error) {
+	t.pendingMu.Lock()
+	if buf := t.pending; buf != nil {
+		t.pending = nil
+		t.pendingMu.Unlock()
+		return buf, nil
+	}
+	t.pendingMu.Unlock()
+
+	select {
+	case buf := <-t.outbound:
+		return buf, nil
+	case <-t.closed:
+		return nil, os.ErrClosed
+	default:
+	}
+	if timeout == 0 {
+		return nil, nil
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case buf := <-t.outbound:
+		return buf, nil
+	case <-t.closed:
+		return nil, os.ErrClosed
+	case <-expired:
+		return nil, nil
+	}
+}
+
+// unpull 放回一个本次放不下的包
+func (t *queueTun) unpull(buf []byte) {
+	t.pendingMu.Lock()
+	t.pending = buf
+	t.pendingMu.Unlock()
+}
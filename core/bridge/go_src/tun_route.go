@@ -0,0 +1,364 @@
+// TUN自动路由
+// 开启后TunStart在系统中安装策略路由，把流量引入TUN接口，TunStop时撤销：
+//   - 专用路由表中为每个路由网段添加指向TUN接口的路由
+//   - priority:   lookup main suppress_prefixlength 0，本机网段等比默认路由更具体的路由仍走主路由表
+//   - priority+1: uidrange <uid> lookup main，分应用列表中不经过代理的UID（见tun_apps.go）
+//   - priority+2: not fwmark <mark> lookup <table>，核心自己的出站连接带fwmark，不进入TUN以免回环
+//   - priority+9: not fwmark <mark> unreachable，仅strictRoute（只能与默认路由一起使用），IPv4和IPv6都添加
+// 规则按优先级区间识别，安装前先清理同一区间和路由表中的残留，核心崩溃后下次启动即可恢复
+// strictRoute用于防泄漏：TUN接口消失（核心崩溃、重启）后路由表为空，未带fwmark的流量在阻断规则处
+// 被拒绝而不是回落到主路由表；TUN没有地址的IP版本也一并阻断。重新安装时先添加阻断规则再清理，
+// 期间不会出现没有规则的空窗；只有主路由表中比默认路由更具体的本机网段和分应用排除的UID不受影响
+// 目前只有Linux桌面端（netlink）支持，移动端由宿主的VpnService/NetworkExtension配置路由
+
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"net/netip"
+	"sync"
+
+	"github.com/metacubex/mihomo/component/dialer"
+	mconfig "github.com/metacubex/mihomo/config"
+)
+
+// 自动路由默认参数
+const (
+	defaultTunRouteTable   = 2022
+	defaultTunRulePriority = 9000
+	defaultTunRoutingMark  = 2158 // 与mihomo iptables模式的默认routing-mark一致
+
+	// 从priority开始保留的优先级数量，该区间内的规则都视为自动路由安装的
+	tunRulePriorityRange = 10
+	// strictRoute阻断规则在区间中的位置
+	tunBlockPriorityOffset = tunRulePriorityRange - 1
+)
+
+// TunRouteOptions SetTunAutoRoute的参数，零值字段使用默认值
+type TunRouteOptions struct {
+	Table    int      `json:"table,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+	FwMark   int      `json:"fwmark,omitempty"` // 配置未设置routing-mark时核心出站连接使用的标记
+	Routes   []string `json:"routes,omitempty"` // 默认按TUN地址的IP版本路由0.0.0.0/0和::/0
+	// 阻断未经过TUN的出站流量，核心崩溃后规则保留，直到下次TunStart重新安装或关闭自动路由
+	// 只能与默认路由（0.0.0.0/0、::/0）一起使用
+	StrictRoute bool `json:"strictRoute,omitempty"`
+}
+
+// tunRouteConfig 解析后的自动路由参数
+type tunRouteConfig struct {
+	table    int
+	priority int
+	mark     int
+	routes   []netip.Prefix
+	strict   bool
+}
+
+// TunRouteStatus 已安装的自动路由，出现在GetTunStats的autoRoute字段
+type TunRouteStatus struct {
+	Interface   string   `json:"interface"`
+	Table       int      `json:"table"`
+	Priority    int      `json:"priority"`
+	FwMark      int      `json:"fwmark"`
+	Routes      []string `json:"routes"`
+	BypassUID   []string `json:"bypassUid,omitempty"` // 不进入TUN的UID区间
+	StrictRoute bool     `json:"strictRoute"`
+}
+
+// 自动路由状态，由tunRouteMu保护；加锁顺序在engineMu、tunMutex之后
+var (
+	tunRouteMu        sync.Mutex
+	tunRouteSettings  *tunRouteConfig // nil表示关闭
+	tunRouteInstalled *TunRouteStatus // nil表示未安装
+	// strictRoute阻断规则已生效，包括TUN启动前保留的上次残留，按tunRouteSettings的区间撤销
+	tunLeakArmed bool
+	// 由自动路由写入dialer.DefaultRoutingMark的标记，0表示标记来自配置或未设置，撤销自动路由时清除
+	tunRoutingMarkSet int32
+)
+
+// parseTunRouteOptions 解析JSON参数，空字符串表示关闭
+func parseTunRouteOptions(text string) (*tunRouteConfig, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	var options TunRouteOptions
+	if err := json.Unmarshal([]byte(text), &options); err != nil {
+		return nil, wrapError(CodeInvalidArgument, err, "无效的自动路由参数")
+	}
+
+	cfg := &tunRouteConfig{
+		table:    options.Table,
+		priority: options.Priority,
+		mark:     options.FwMark,
+		strict:   options.StrictRoute,
+	}
+	if cfg.table == 0 {
+		cfg.table = defaultTunRouteTable
+	}
+	if cfg.priority == 0 {
+		cfg.priority = defaultTunRulePriority
+	}
+	if cfg.mark == 0 {
+		cfg.mark = defaultTunRoutingMark
+	}
+	// 0和253-255为内核保留的路由表
+	if cfg.table < 1 || (cfg.table >= 253 && cfg.table <= 255) {
+		return nil, newError(CodeInvalidArgument, "无效的路由表: %d", cfg.table)
+	}
+	if cfg.priority < 1 || cfg.priority > 32765-tunRulePriorityRange {
+		return nil, newError(CodeInvalidArgument, "无效的规则优先级: %d", cfg.priority)
+	}
+	if cfg.mark < 0 {
+		return nil, newError(CodeInvalidArgument, "无效的fwmark: %d", cfg.mark)
+	}
+
+	for _, route := range options.Routes {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return nil, wrapError(CodeInvalidArgument, err, "无效的路由网段: %q", route)
+		}
+		// suppress_prefixlength 0隐藏了主路由表的默认路由，strictRoute下指定网段以外的流量会全部被阻断
+		if cfg.strict && prefix.Bits() != 0 {
+			return nil, newError(CodeInvalidArgument, "strictRoute只能与默认路由一起使用: %q", route)
+		}
+		cfg.routes = append(cfg.routes, prefix.Masked())
+	}
+	return cfg, nil
+}
+
+// routesFor 按TUN地址过滤路由网段，没有指定时路由TUN地址所有IP版本的全部流量
+func (c *tunRouteConfig) routesFor(tun tunConfig) []netip.Prefix {
+	routes := c.routes
+	if len(routes) == 0 {
+		routes = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+	}
+
+	selected := make([]netip.Prefix, 0, len(routes))
+	for _, route := range routes {
+		if _, ok := tun.address(route.Addr().Is6()); !ok {
+			tunLog.Warnf("TUN接口没有该IP版本的地址，跳过路由 %s", route)
+			continue
+		}
+		selected = append(selected, route)
+	}
+	return selected
+}
+
+// routingMark 核心出站连接的fwmark，配置设置了routing-mark时使用配置的值
+// 引擎每次应用配置都会重置标记，由syncTunRoutingMark重新设置；调用者需持有tunRouteMu
+func (c *tunRouteConfig) routingMark() int {
+	if dialer.DefaultRoutingMark.CompareAndSwap(0, int32(c.mark)) {
+		tunRoutingMarkSet = int32(c.mark)
+	}
+	return int(dialer.DefaultRoutingMark.Load())
+}
+
+// releaseTunRoutingMarkLocked 清除自动路由写入的出站标记，配置设置的标记保持不变，调用者需持有tunRouteMu
+func releaseTunRoutingMarkLocked() {
+	if tunRoutingMarkSet == 0 {
+		return
+	}
+	dialer.DefaultRoutingMark.CompareAndSwap(tunRoutingMarkSet, 0)
+	tunRoutingMarkSet = 0
+}
+
+// syncTunRoutingMark 引擎应用配置后调用，自动路由已安装时保持出站连接的标记
+// 标记与已安装规则不一致时（配置修改了routing-mark）重新安装规则
+func syncTunRoutingMark() {
+	tun := currentTunConfigSnapshot()
+
+	tunRouteMu.Lock()
+	defer tunRouteMu.Unlock()
+
+	installed := tunRouteInstalled
+	if installed == nil || tunRouteSettings == nil {
+		return
+	}
+	if mark := tunRouteSettings.routingMark(); mark != installed.FwMark {
+		tunLog.Infof("routing-mark变为%#x，重新安装自动路由", mark)
+		if err := installTunRoutesLocked(installed.Interface, tun); err != nil {
+			tunLog.Errorf("重新安装自动路由失败: %v", err)
+		}
+	}
+}
+
+// startTunRoutes TunStart时安装自动路由，未开启时什么都不做
+func startTunRoutes(name string, tun tunConfig) error {
+	tunRouteMu.Lock()
+	defer tunRouteMu.Unlock()
+
+	if tunRouteSettings == nil {
+		return nil
+	}
+	return installTunRoutesLocked(name, tun)
+}
+
+// installTunRoutesLocked 清理残留后安装路由和规则，失败时撤销已安装的部分，调用者需持有tunRouteMu
+func installTunRoutesLocked(name string, tun tunConfig) error {
+	cfg := tunRouteSettings
+	tunRouteInstalled = nil
+
+	status := &TunRouteStatus{
+		Interface:   name,
+		Table:       cfg.table,
+		Priority:    cfg.priority,
+		FwMark:      cfg.routingMark(),
+		Routes:      []string{},
+		StrictRoute: cfg.strict,
+	}
+	routes := cfg.routesFor(tun)
+	for _, route := range routes {
+		status.Routes = append(status.Routes, route.String())
+	}
+	bypass := tunAppFilterValue.Load().bypassUIDRanges()
+	for _, r := range bypass {
+		status.BypassUID = append(status.BypassUID, r.String())
+	}
+
+	if err := installTunRoutes(name, status, routes, bypass); err != nil {
+		tunLeakArmed = false
+		releaseTunRoutingMarkLocked()
+		if _, _, cleanupErr := removeTunRoutes(cfg.table, cfg.priority, false); cleanupErr != nil {
+			tunLog.Warnf("撤销自动路由失败: %v", cleanupErr)
+		}
+		return err
+	}
+
+	tunRouteInstalled = status
+	tunLeakArmed = cfg.strict
+	tunLog.Infof("已安装自动路由: %s 表%d 优先级%d fwmark %#x 路由%v strictRoute=%v",
+		name, status.Table, status.Priority, status.FwMark, status.Routes, status.StrictRoute)
+	return nil
+}
+
+// tunRouteRangeLocked 已安装的规则或保留的阻断规则所在的路由表和优先级，调用者需持有tunRouteMu
+func tunRouteRangeLocked() (table, priority int, ok bool) {
+	switch {
+	case tunRouteInstalled != nil:
+		return tunRouteInstalled.Table, tunRouteInstalled.Priority, true
+	case tunLeakArmed && tunRouteSettings != nil:
+		return tunRouteSettings.table, tunRouteSettings.priority, true
+	}
+	return 0, 0, false
+}
+
+// disarmTunRoutesLocked 撤销已安装的自动路由和保留的阻断规则，调用者需持有tunRouteMu
+func disarmTunRoutesLocked() error {
+	releaseTunRoutingMarkLocked()
+	table, priority, ok := tunRouteRangeLocked()
+	if !ok {
+		return nil
+	}
+	tunRouteInstalled = nil
+	tunLeakArmed = false
+
+	_, _, err := removeTunRoutes(table, priority, false)
+	return err
+}
+
+// stopTunRoutes TunStop时撤销自动路由
+func stopTunRoutes() {
+	tunRouteMu.Lock()
+	defer tunRouteMu.Unlock()
+
+	if tunRouteInstalled == nil && !tunLeakArmed {
+		releaseTunRoutingMarkLocked()
+		return
+	}
+	if err := disarmTunRoutesLocked(); err != nil {
+		tunLog.Errorf("撤销自动路由失败: %v", err)
+		return
+	}
+	tunLog.Infof("已撤销自动路由")
+}
+
+// prepareTunRoutingMark 应用配置前把已安装规则的fwmark写入未设置routing-mark的配置，
+// 避免重载时标记先被重置为0，核心出站连接在syncTunRoutingMark之前进入TUN
+func prepareTunRoutingMark(cfg *mconfig.Config) {
+	tunRouteMu.Lock()
+	defer tunRouteMu.Unlock()
+
+	if tunRouteInstalled != nil && cfg.General.RoutingMark == 0 {
+		cfg.General.RoutingMark = tunRouteInstalled.FwMark
+		tunRoutingMarkSet = int32(tunRouteInstalled.FwMark)
+	}
+}
+
+// tunRouteStatus 已安装的自动路由
+func tunRouteStatus() *TunRouteStatus {
+	tunRouteMu.Lock()
+	defer tunRouteMu.Unlock()
+	return tunRouteInstalled
+}
+
+// tunLeakProtection strictRoute阻断规则是否生效
+func tunLeakProtection() bool {
+	tunRouteMu.Lock()
+	defer tunRouteMu.Unlock()
+	return tunLeakArmed
+}
+
+// currentTunConfigSnapshot 读取当前接口参数
+func currentTunConfigSnapshot() tunConfig {
+	tunMutex.RLock()
+	defer tunMutex.RUnlock()
+	return currentTunConfig
+}
+
+// 设置TUN自动路由，options为JSON {"table","priority","fwmark","routes","strictRoute"}，零值字段使用默认值，空字符串关闭
+// 在TunStart时安装、TunStop时撤销；TUN已启动时立即按新参数重新安装
+// 开启时会先清理同一路由表和优先级区间中的残留，用于核心异常退出后的恢复；strictRoute时保留残留的阻断规则
+//
+//export SetTunAutoRoute
+func SetTunAutoRoute(cOptions *C.char) (ret int32) {
+	defer recoverCode(&ret)
+
+	cfg, err := parseTunRouteOptions(C.GoString(cOptions))
+	if err != nil {
+		return setLastError(err)
+	}
+
+	tunMutex.RLock()
+	defer tunMutex.RUnlock()
+	tunRouteMu.Lock()
+	defer tunRouteMu.Unlock()
+
+	// 区间不变时旧规则由下面的重新安装或清理处理，strictRoute的阻断规则在此期间一直生效
+	table, priority, ok := tunRouteRangeLocked()
+	if cfg == nil || !ok || table != cfg.table || priority != cfg.priority {
+		if err := disarmTunRoutesLocked(); err != nil {
+			return setLastError(err)
+		}
+	}
+	tunRouteSettings = cfg
+
+	if cfg == nil {
+		tunLog.Infof("关闭TUN自动路由")
+		return CodeSuccess
+	}
+	if tunStarted {
+		if err := installTunRoutesLocked(tunInterface, currentTunConfig); err != nil {
+			return setLastError(err)
+		}
+		return CodeSuccess
+	}
+
+	// TUN未启动时只清理上次异常退出留下的规则，没有权限时不影响设置
+	// strictRoute时保留阻断规则，TunStart之前流量仍不会绕过TUN
+	removed, kept, err := removeTunRoutes(cfg.table, cfg.priority, cfg.strict)
+	if err != nil {
+		tunLog.Warnf("清理残留的自动路由失败: %v", err)
+	} else {
+		tunLeakArmed = kept > 0
+		if removed > 0 {
+			tunLog.Infof("已清理%d条残留的自动路由规则和路由", removed)
+		}
+		if kept > 0 {
+			tunLog.Infof("保留%d条残留的阻断规则，TunStart前继续阻断未经过TUN的流量", kept)
+		}
+	}
+	tunLog.Infof("开启TUN自动路由: 表%d 优先级%d strictRoute=%v", cfg.table, cfg.priority, cfg.strict)
+	return CodeSuccess
+}
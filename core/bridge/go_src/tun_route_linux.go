@@ -0,0 +1,315 @@
+//go:build linux && !android
+
+// Linux自动路由
+// 通过rtnetlink增删路由和策略规则，相当于：
+//   ip route add <route> dev <tun> table <table>
+//   ip rule add lookup main suppress_prefixlength 0 pref <priority>
+//   ip rule add uidrange <start>-<end> lookup main pref <priority+1>
+//   ip rule add not fwmark <mark> lookup <table> pref <priority+2>
+//   ip rule add not fwmark <mark> unreachable pref <priority+9>   (strictRoute)
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+// routeRule 一条策略路由规则
+type routeRule struct {
+	family   uint8
+	priority int
+	table    int
+	mark     int      // >0时匹配该fwmark
+	uids     uidRange // hasUIDs时匹配该UID区间
+	hasUIDs  bool
+	invert   bool // 取反匹配条件（not）
+	suppress bool // suppress_prefixlength 0
+	block    bool // unreachable，不查路由表
+}
+
+// tunRouteRules 自动路由的规则，每个路由的IP版本一组
+func tunRouteRules(status *TunRouteStatus, routes []netip.Prefix, bypass []uidRange) []routeRule {
+	rules := []routeRule{}
+	for _, family := range routeFamilies(routes) {
+		rules = append(rules, routeRule{family: family, priority: status.Priority, table: unix.RT_TABLE_MAIN, suppress: true})
+		for _, uids := range bypass {
+			rules = append(rules, routeRule{family: family, priority: status.Priority + 1, table: unix.RT_TABLE_MAIN, uids: uids, hasUIDs: true})
+		}
+		rules = append(rules, routeRule{family: family, priority: status.Priority + 2, table: status.Table, mark: status.FwMark, invert: true})
+	}
+	return rules
+}
+
+// tunBlockRules strictRoute的阻断规则，不论TUN有哪些IP版本的地址都阻断
+func tunBlockRules(status *TunRouteStatus) []routeRule {
+	rules := []routeRule{}
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		rules = append(rules, routeRule{
+			family:   family,
+			priority: status.Priority + tunBlockPriorityOffset,
+			mark:     status.FwMark,
+			invert:   true,
+			block:    true,
+		})
+	}
+	return rules
+}
+
+// routeFamilies 路由网段涉及的地址族
+func routeFamilies(routes []netip.Prefix) []uint8 {
+	var has4, has6 bool
+	for _, route := range routes {
+		if route.Addr().Is6() {
+			has6 = true
+		} else {
+			has4 = true
+		}
+	}
+	families := []uint8{}
+	if has4 {
+		families = append(families, unix.AF_INET)
+	}
+	if has6 {
+		families = append(families, unix.AF_INET6)
+	}
+	return families
+}
+
+// installTunRoutes 清理残留后添加路由表和规则
+func installTunRoutes(name string, status *TunRouteStatus, routes []netip.Prefix, bypass []uidRange) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return wrapError(CodeTunDevice, err, "找不到TUN接口%s", name)
+	}
+
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return tunSyscallError(err, "连接rtnetlink失败")
+	}
+	defer conn.Close()
+
+	// strictRoute时先添加阻断规则，清理旧规则到添加新规则之间未带fwmark的流量被拒绝而不是泄漏
+	var keep func(priority int, mark uint32) bool
+	if status.StrictRoute {
+		for _, rule := range tunBlockRules(status) {
+			if err := addRule(conn, rule); err != nil && !errors.Is(err, unix.EEXIST) {
+				return tunSyscallError(err, "添加优先级%d的阻断规则失败", rule.priority)
+			}
+		}
+		keep = func(priority int, mark uint32) bool {
+			return priority == status.Priority+tunBlockPriorityOffset && mark == uint32(status.FwMark)
+		}
+	}
+	if _, _, err := cleanupRoutes(conn, status.Table, status.Priority, keep); err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		msg := routeMessage(route, status.Table, iface.Index)
+		msg.Header.Type = unix.RTM_NEWROUTE
+		msg.Header.Flags = netlink.Request | netlink.Acknowledge | netlink.Create | netlink.Excl
+		if _, err := conn.Execute(msg); err != nil {
+			return tunSyscallError(err, "添加路由%s dev %s table %d失败", route, name, status.Table)
+		}
+	}
+
+	for _, rule := range tunRouteRules(status, routes, bypass) {
+		if err := addRule(conn, rule); err != nil {
+			return tunSyscallError(err, "添加优先级%d的路由规则失败", rule.priority)
+		}
+	}
+	return nil
+}
+
+// addRule 添加一条策略路由规则，内核未启用IPv6时忽略IPv6的阻断规则
+func addRule(conn *netlink.Conn, rule routeRule) error {
+	msg := rule.message()
+	msg.Header.Type = unix.RTM_NEWRULE
+	msg.Header.Flags = netlink.Request | netlink.Acknowledge | netlink.Create | netlink.Excl
+	_, err := conn.Execute(msg)
+	if rule.block && rule.family == unix.AF_INET6 && errors.Is(err, unix.EAFNOSUPPORT) {
+		return nil
+	}
+	return err
+}
+
+// removeTunRoutes 删除优先级区间内的规则和路由表中的全部路由，返回删除和保留的条数
+// keepBlock为true时保留阻断规则
+func removeTunRoutes(table, priority int, keepBlock bool) (removed, kept int, err error) {
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return 0, 0, tunSyscallError(err, "连接rtnetlink失败")
+	}
+	defer conn.Close()
+
+	var keep func(int, uint32) bool
+	if keepBlock {
+		keep = func(p int, _ uint32) bool { return p == priority+tunBlockPriorityOffset }
+	}
+	return cleanupRoutes(conn, table, priority, keep)
+}
+
+// cleanupRoutes 先删规则再清空路由表，避免中途流量被引入空表
+// keep不为nil时保留按优先级和fwmark选中的规则
+func cleanupRoutes(conn *netlink.Conn, table, priority int, keep func(priority int, mark uint32) bool) (removed, kept int, err error) {
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		rules, err := dumpRoutes(conn, unix.RTM_GETRULE, family)
+		if err != nil {
+			return removed, kept, err
+		}
+		for _, rule := range rules {
+			p := int(routeAttribute(rule, unix.FRA_PRIORITY))
+			if p < priority || p >= priority+tunRulePriorityRange {
+				continue
+			}
+			if keep != nil && keep(p, routeAttribute(rule, unix.FRA_FWMARK)) {
+				kept++
+				continue
+			}
+			if err := deleteRoute(conn, unix.RTM_DELRULE, rule); err != nil {
+				return removed, kept, tunSyscallError(err, "删除优先级%d的路由规则失败", p)
+			}
+			removed++
+		}
+
+		routes, err := dumpRoutes(conn, unix.RTM_GETROUTE, family)
+		if err != nil {
+			return removed, kept, err
+		}
+		for _, route := range routes {
+			if int(routeAttribute(route, unix.RTA_TABLE)) != table {
+				continue
+			}
+			if err := deleteRoute(conn, unix.RTM_DELROUTE, route); err != nil {
+				return removed, kept, tunSyscallError(err, "删除路由表%d中的路由失败", table)
+			}
+			removed++
+		}
+	}
+	return removed, kept, nil
+}
+
+// dumpRoutes 列出一个地址族的规则或路由
+// 规则头fib_rule_hdr与路由头rtmsg同为12字节，且地址族都在第一个字节
+func dumpRoutes(conn *netlink.Conn, msgType netlink.HeaderType, family uint8) ([]netlink.Message, error) {
+	header := make([]byte, unix.SizeofRtMsg)
+	header[0] = family
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{Type: msgType, Flags: netlink.Request | netlink.Dump},
+		Data:   header,
+	})
+	if err != nil {
+		// 内核未启用IPv6时没有对应的地址族
+		if family == unix.AF_INET6 && errors.Is(err, unix.EAFNOSUPPORT) {
+			return nil, nil
+		}
+		return nil, tunSyscallError(err, "读取路由信息失败")
+	}
+	return msgs, nil
+}
+
+// deleteRoute 按列出的内容原样删除一条规则或路由，已不存在时忽略
+func deleteRoute(conn *netlink.Conn, msgType netlink.HeaderType, msg netlink.Message) error {
+	_, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{Type: msgType, Flags: netlink.Request | netlink.Acknowledge},
+		Data:   msg.Data,
+	})
+	if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// routeAttribute 读取规则或路由的一个uint32属性，表号缺少属性时取头部的值
+func routeAttribute(msg netlink.Message, attrType uint16) uint32 {
+	if len(msg.Data) < unix.SizeofRtMsg {
+		return 0
+	}
+	value := uint32(0)
+	if attrType == unix.RTA_TABLE || attrType == unix.FRA_TABLE {
+		value = uint32(msg.Data[4])
+	}
+
+	ad, err := netlink.NewAttributeDecoder(msg.Data[unix.SizeofRtMsg:])
+	if err != nil {
+		return value
+	}
+	for ad.Next() {
+		if ad.Type() == attrType {
+			value = ad.Uint32()
+		}
+	}
+	return value
+}
+
+// message 编码为RTM_NEWRULE/RTM_DELRULE的消息体
+func (r routeRule) message() netlink.Message {
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.FRA_PRIORITY, uint32(r.priority))
+	if !r.block {
+		ae.Uint32(unix.FRA_TABLE, uint32(r.table))
+	}
+	if r.mark > 0 {
+		ae.Uint32(unix.FRA_FWMARK, uint32(r.mark))
+		ae.Uint32(unix.FRA_FWMASK, 0xffffffff)
+	}
+	if r.hasUIDs {
+		// fib_rule_uid_range: start end
+		ae.Bytes(unix.FRA_UID_RANGE, append(nlenc.Uint32Bytes(r.uids.start), nlenc.Uint32Bytes(r.uids.end)...))
+	}
+	if r.suppress {
+		ae.Uint32(unix.FRA_SUPPRESS_PREFIXLEN, 0)
+	}
+	attrs, _ := ae.Encode()
+
+	var flags uint32
+	if r.invert {
+		flags |= unix.FIB_RULE_INVERT
+	}
+	action := uint8(unix.FR_ACT_TO_TBL)
+	if r.block {
+		action = unix.FR_ACT_UNREACHABLE
+	}
+	// fib_rule_hdr: family dst_len src_len tos table res1 res2 action flags
+	header := []byte{r.family, 0, 0, 0, routeTableByte(r.table), 0, 0, action}
+	header = append(header, nlenc.Uint32Bytes(flags)...)
+	return netlink.Message{Data: append(header, attrs...)}
+}
+
+// routeMessage 编码一条指向接口的路由
+func routeMessage(prefix netip.Prefix, table, ifindex int) netlink.Message {
+	family := uint8(unix.AF_INET)
+	if prefix.Addr().Is6() {
+		family = unix.AF_INET6
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.RTA_TABLE, uint32(table))
+	ae.Uint32(unix.RTA_OIF, uint32(ifindex))
+	if prefix.Bits() > 0 {
+		ae.Bytes(unix.RTA_DST, prefix.Addr().AsSlice())
+	}
+	attrs, _ := ae.Encode()
+
+	// rtmsg: family dst_len src_len tos table protocol scope type flags
+	header := []byte{
+		family, uint8(prefix.Bits()), 0, 0, routeTableByte(table),
+		unix.RTPROT_STATIC, unix.RT_SCOPE_LINK, unix.RTN_UNICAST,
+	}
+	header = append(header, nlenc.Uint32Bytes(0)...)
+	return netlink.Message{Data: append(header, attrs...)}
+}
+
+// routeTableByte 头部只能容纳0-255的表号，更大的表号只通过属性传递
+func routeTableByte(table int) uint8 {
+	if table > 255 {
+		return unix.RT_TABLE_UNSPEC
+	}
+	return uint8(table)
+}
@@ -0,0 +1,67 @@
+//go:build linux && !android
+
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestTunStrictRouteRules strictRoute时安装的全部规则：TUN地址的IP版本走路由表，阻断规则两个IP版本都有
+func TestTunStrictRouteRules(t *testing.T) {
+	status := &TunRouteStatus{Table: 2022, Priority: 9000, FwMark: 2158, StrictRoute: true}
+	bypass := []uidRange{{start: 1000, end: 1000}}
+
+	tests := []struct {
+		name      string
+		addresses []string
+		want      []routeRule
+	}{
+		{
+			name:      "只有IPv4",
+			addresses: []string{"198.18.0.1/30"},
+			want: []routeRule{
+				{family: unix.AF_INET, priority: 9000, table: unix.RT_TABLE_MAIN, suppress: true},
+				{family: unix.AF_INET, priority: 9001, table: unix.RT_TABLE_MAIN, uids: bypass[0], hasUIDs: true},
+				{family: unix.AF_INET, priority: 9002, table: 2022, mark: 2158, invert: true},
+				{family: unix.AF_INET, priority: 9009, mark: 2158, invert: true, block: true},
+				{family: unix.AF_INET6, priority: 9009, mark: 2158, invert: true, block: true},
+			},
+		},
+		{
+			name:      "双栈",
+			addresses: []string{"198.18.0.1/30", "fdfe:dcba:9876::1/126"},
+			want: []routeRule{
+				{family: unix.AF_INET, priority: 9000, table: unix.RT_TABLE_MAIN, suppress: true},
+				{family: unix.AF_INET, priority: 9001, table: unix.RT_TABLE_MAIN, uids: bypass[0], hasUIDs: true},
+				{family: unix.AF_INET, priority: 9002, table: 2022, mark: 2158, invert: true},
+				{family: unix.AF_INET6, priority: 9000, table: unix.RT_TABLE_MAIN, suppress: true},
+				{family: unix.AF_INET6, priority: 9001, table: unix.RT_TABLE_MAIN, uids: bypass[0], hasUIDs: true},
+				{family: unix.AF_INET6, priority: 9002, table: 2022, mark: 2158, invert: true},
+				{family: unix.AF_INET, priority: 9009, mark: 2158, invert: true, block: true},
+				{family: unix.AF_INET6, priority: 9009, mark: 2158, invert: true, block: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseTunRouteOptions(`{"strictRoute":true}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tun := tunConfig{}
+			for _, address := range tt.addresses {
+				tun.Addresses = append(tun.Addresses, netip.MustParsePrefix(address))
+			}
+			routes := cfg.routesFor(tun)
+			got := append(tunRouteRules(status, routes, bypass), tunBlockRules(status)...)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("规则 = %+v\n期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux || android
+
+// 非Linux桌面平台的自动路由
+// 移动端的路由由宿主的VpnService/NetworkExtension配置，核心不修改系统路由
+
+package main
+
+import "net/netip"
+
+// installTunRoutes 当前平台不支持由核心安装路由
+func installTunRoutes(name string, status *TunRouteStatus, routes []netip.Prefix, bypass []uidRange) error {
+	return newError(CodeTunUnsupported, "当前平台不支持TUN自动路由，需由宿主配置路由")
+}
+
+// removeTunRoutes 当前平台没有需要撤销的路由
+func removeTunRoutes(table, priority int, keepBlock bool) (removed, kept int, err error) {
+	return 0, 0, nil
+}
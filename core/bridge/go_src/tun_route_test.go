@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestRoutesFor(t *testing.T) {
+	prefixes := func(list ...string) []netip.Prefix {
+		result := make([]netip.Prefix, 0, len(list))
+		for _, prefix := range list {
+			result = append(result, netip.MustParsePrefix(prefix))
+		}
+		return result
+	}
+
+	tests := []struct {
+		name      string
+		routes    []string
+		addresses []string
+		want      []string
+	}{
+		{name: "默认路由-只有IPv4", addresses: []string{"198.18.0.1/30"}, want: []string{"0.0.0.0/0"}},
+		{name: "默认路由-只有IPv6", addresses: []string{"fdfe:dcba:9876::1/126"}, want: []string{"::/0"}},
+		{name: "默认路由-双栈", addresses: []string{"198.18.0.1/30", "fdfe:dcba:9876::1/126"}, want: []string{"0.0.0.0/0", "::/0"}},
+		{name: "指定网段-只有IPv4", routes: []string{"10.0.0.0/8", "fd00::/8"}, addresses: []string{"198.18.0.1/30"}, want: []string{"10.0.0.0/8"}},
+		{name: "指定网段-只有IPv6", routes: []string{"10.0.0.0/8", "fd00::/8"}, addresses: []string{"fdfe:dcba:9876::1/126"}, want: []string{"fd00::/8"}},
+		{name: "指定网段-双栈", routes: []string{"10.0.0.0/8", "fd00::/8"}, addresses: []string{"198.18.0.1/30", "fdfe:dcba:9876::1/126"}, want: []string{"10.0.0.0/8", "fd00::/8"}},
+		{name: "没有地址", routes: []string{"10.0.0.0/8"}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &tunRouteConfig{routes: prefixes(tt.routes...)}
+			got := cfg.routesFor(tunConfig{Addresses: prefixes(tt.addresses...)})
+			if want := prefixes(tt.want...); !slices.Equal(got, want) {
+				t.Fatalf("routesFor = %v，期望 %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseTunRouteOptionsStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		valid   bool
+	}{
+		{name: "默认路由", options: `{"strictRoute":true}`, valid: true},
+		{name: "显式默认路由", options: `{"strictRoute":true,"routes":["0.0.0.0/0","::/0"]}`, valid: true},
+		{name: "指定网段", options: `{"strictRoute":true,"routes":["10.0.0.0/8"]}`, valid: false},
+		{name: "默认路由和指定网段", options: `{"strictRoute":true,"routes":["0.0.0.0/0","fd00::/8"]}`, valid: false},
+		{name: "非strictRoute指定网段", options: `{"routes":["10.0.0.0/8"]}`, valid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTunRouteOptions(tt.options)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("parseTunRouteOptions: %v", err)
+				}
+				return
+			}
+			var bridgeErr *BridgeError
+			if !errors.As(err, &bridgeErr) || bridgeErr.Code != CodeInvalidArgument {
+				t.Fatalf("parseTunRouteOptions = %v，期望 %s", err, codeName(CodeInvalidArgument))
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// TUN流量计数
+// 数据包路径上只做原子操作，不获取tunMutex；读取方通过序号取得一致的快照
+
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// tunCounter 单方向的包数和字节数
+// 每个方向只有一个写入者（入站为读取循环，出站为写回循环），写入前后各递增一次seq，
+// seq为奇数表示正在写入，读取方看到前后seq相同且为偶数时包数和字节数是一致的
+type tunCounter struct {
+	seq     atomic.Uint64
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+// add 记录一个数据包，只能由该方向唯一的写入者调用
+func (c *tunCounter) add(n int) {
+	c.seq.Add(1)
+	c.packets.Add(1)
+	c.bytes.Add(uint64(n))
+	c.seq.Add(1)
+}
+
+// load 读取一致的包数和字节数
+func (c *tunCounter) load() (packets, bytes uint64) {
+	for {
+		seq := c.seq.Load()
+		if seq%2 == 0 {
+			packets, bytes = c.packets.Load(), c.bytes.Load()
+			if c.seq.Load() == seq {
+				return packets, bytes
+			}
+		}
+		// 写入者在两次递增之间被调度出去时让出CPU
+		runtime.Gosched()
+	}
+}
+
+// TunStats TUN流量统计，ResetTunStats时整体替换，替换前的计数不再计入
+type TunStats struct {
+	in        tunCounter
+	out       tunCounter
+	startTime time.Time
+
+	// 分类统计，见tun_breakdown.go
+	protocols    [tunProtocolCount]tunTrafficPair
+	versions     [tunIPVersionCount]tunTrafficPair
+	destinations tunDestinationTable
+
+	// 被劫持并由核心应答的DNS查询数（TCP按连接计）
+	dnsHijacked atomic.Uint64
+	// 按分应用列表改走DIRECT的连接数（UDP按流计）
+	appBypassed atomic.Uint64
+}
+
+// countIn 记录一个从TUN读出的包，只能由读取循环调用
+func (s *TunStats) countIn(packet []byte) {
+	s.in.add(len(packet))
+	if version, protocol, ok := classifyPacket(packet); ok {
+		s.versions[version].in.add(len(packet))
+		s.protocols[protocol].in.add(len(packet))
+	}
+}
+
+// countOut 记录一个写回TUN的包，只能由写回循环调用
+func (s *TunStats) countOut(packet []byte) {
+	s.out.add(len(packet))
+	if version, protocol, ok := classifyPacket(packet); ok {
+		s.versions[version].out.add(len(packet))
+		s.protocols[protocol].out.add(len(packet))
+	}
+}
+
+// tunStatsSnapshot 某一时刻的统计快照
+type tunStatsSnapshot struct {
+	PacketsIn  uint64
+	PacketsOut uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	StartTime  time.Time
+}
+
+// tunStats 当前统计，数据包路径直接通过指针计数
+var tunStats atomic.Pointer[TunStats]
+
+func init() {
+	tunStats.Store(&TunStats{})
+}
+
+// resetTunStats 从零开始计数
+func resetTunStats() {
+	tunStats.Store(&TunStats{startTime: time.Now()})
+}
+
+// snapshot 读取两个方向的计数
+func (s *TunStats) snapshot() tunStatsSnapshot {
+	snap := tunStatsSnapshot{StartTime: s.startTime}
+	snap.PacketsIn, snap.BytesIn = s.in.load()
+	snap.PacketsOut, snap.BytesOut = s.out.load()
+	return snap
+}
+
+// Uptime 统计开始至今的秒数
+func (s tunStatsSnapshot) Uptime() int64 {
+	if s.StartTime.IsZero() {
+		return 0
+	}
+	return int64(time.Since(s.StartTime).Seconds())
+}
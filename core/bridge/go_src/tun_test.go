@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metacubex/gvisor/pkg/tcpip"
+	"github.com/metacubex/gvisor/pkg/tcpip/checksum"
+	"github.com/metacubex/gvisor/pkg/tcpip/header"
+)
+
+// startTestCore 用最小配置启动核心，测试结束时停止
+func startTestCore(t *testing.T, rules ...string) {
+	t.Helper()
+
+	config := "mode: rule\nlog-level: silent\nrules:\n"
+	for _, rule := range rules {
+		config += "  - " + rule + "\n"
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lifecycle.transition(StateInitialized, "test"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	configMap["path"] = path
+	mu.Unlock()
+
+	if code := StartMihomoProxy(); code != CodeSuccess {
+		t.Fatalf("StartMihomoProxy = %s", codeName(code))
+	}
+	t.Cleanup(func() {
+		if code := StopMihomoProxy(); code != CodeSuccess {
+			t.Errorf("StopMihomoProxy = %s", codeName(code))
+		}
+	})
+}
+
+// ipPacket 按地址的IP版本封装传输层数据
+func ipPacket(src, dst netip.Addr, protocol tcpip.TransportProtocolNumber, transport []byte) []byte {
+	if src.Is4() {
+		packet := make([]byte, header.IPv4MinimumSize+len(transport))
+		ip := header.IPv4(packet)
+		ip.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(packet)),
+			TTL:         64,
+			Protocol:    uint8(protocol),
+			SrcAddr:     tcpip.AddrFrom4(src.As4()),
+			DstAddr:     tcpip.AddrFrom4(dst.As4()),
+		})
+		ip.SetChecksum(^ip.CalculateChecksum())
+		copy(ip.Payload(), transport)
+		return packet
+	}
+
+	packet := make([]byte, header.IPv6MinimumSize+len(transport))
+	ip := header.IPv6(packet)
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(len(transport)),
+		TransportProtocol: protocol,
+		HopLimit:          64,
+		SrcAddr:           tcpip.AddrFrom16(src.As16()),
+		DstAddr:           tcpip.AddrFrom16(dst.As16()),
+	})
+	copy(ip.Payload(), transport)
+	return packet
+}
+
+// pseudoChecksum 传输层校验和的伪首部部分
+func pseudoChecksum(protocol tcpip.TransportProtocolNumber, src, dst netip.Addr, length int) uint16 {
+	return header.PseudoHeaderChecksum(protocol, tcpip.AddrFromSlice(src.AsSlice()), tcpip.AddrFromSlice(dst.AsSlice()), uint16(length))
+}
+
+// tcpSYN 构造一个TCP SYN包，IP版本与地址一致
+func tcpSYN(src, dst netip.AddrPort) []byte {
+	return tcpSegment(src, dst, 1, 0, header.TCPFlagSyn)
+}
+
+// tcpSegment 构造一个不带数据的TCP报文
+func tcpSegment(src, dst netip.AddrPort, seq, ack uint32, flags header.TCPFlags) []byte {
+	tcp := header.TCP(make([]byte, header.TCPMinimumSize))
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    src.Port(),
+		DstPort:    dst.Port(),
+		SeqNum:     seq,
+		AckNum:     ack,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      flags,
+		WindowSize: 65535,
+	})
+	tcp.SetChecksum(^tcp.CalculateChecksum(pseudoChecksum(header.TCPProtocolNumber, src.Addr(), dst.Addr(), len(tcp))))
+	return ipPacket(src.Addr(), dst.Addr(), header.TCPProtocolNumber, tcp)
+}
+
+// udpPacket 构造一个UDP包，IP版本与地址一致
+func udpPacket(src, dst netip.AddrPort, payload []byte) []byte {
+	udp := header.UDP(make([]byte, header.UDPMinimumSize+len(payload)))
+	udp.Encode(&header.UDPFields{
+		SrcPort: src.Port(),
+		DstPort: dst.Port(),
+		Length:  uint16(len(udp)),
+	})
+	copy(udp.Payload(), payload)
+	sum := pseudoChecksum(header.UDPProtocolNumber, src.Addr(), dst.Addr(), len(udp))
+	udp.SetChecksum(^udp.CalculateChecksum(checksum.Checksum(payload, sum)))
+	return ipPacket(src.Addr(), dst.Addr(), header.UDPProtocolNumber, udp)
+}
+
+// transportOf 取出IPv4或IPv6包的传输层协议和数据
+func transportOf(packet []byte) (tcpip.TransportProtocolNumber, []byte, bool) {
+	switch header.IPVersion(packet) {
+	case header.IPv4Version:
+		ip := header.IPv4(packet)
+		if !ip.IsValid(len(packet)) {
+			return 0, nil, false
+		}
+		return ip.TransportProtocol(), ip.Payload(), true
+	case header.IPv6Version:
+		ip := header.IPv6(packet)
+		if !ip.IsValid(len(packet)) {
+			return 0, nil, false
+		}
+		return ip.TransportProtocol(), ip.Payload(), true
+	}
+	return 0, nil, false
+}
+
+// pullSYNACK 从出站队列中等待协议栈应答的SYN-ACK，返回其序号
+func pullSYNACK(t *testing.T, queue *queueTun, port uint16) uint32 {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		buf, err := queue.pull(100 * time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf == nil {
+			continue
+		}
+		protocol, payload, ok := transportOf(buf)
+		if ok && protocol == header.TCPProtocolNumber {
+			tcp := header.TCP(payload)
+			if tcp.DestinationPort() == port && tcp.Flags() == header.TCPFlagSyn|header.TCPFlagAck {
+				seq := tcp.SequenceNumber()
+				queue.release(buf)
+				return seq
+			}
+		}
+		queue.release(buf)
+	}
+	t.Fatalf("没有收到端口%d的SYN-ACK", port)
+	return 0
+}
+
+// tcpHandshake 经宿主队列完成三次握手，协议栈随后把连接交给tunnel
+func tcpHandshake(t *testing.T, queue *queueTun, src, dst netip.AddrPort) {
+	t.Helper()
+
+	if ok, err := queue.push(tcpSYN(src, dst)); !ok || err != nil {
+		t.Fatalf("push SYN = %v, %v", ok, err)
+	}
+	seq := pullSYNACK(t, queue, src.Port())
+	if ok, err := queue.push(tcpSegment(src, dst, 2, seq+1, header.TCPFlagAck)); !ok || err != nil {
+		t.Fatalf("push ACK = %v, %v", ok, err)
+	}
+}
+
+// TestTunQueueLifecycle 宿主队列设备反复创建、启动、停止，期间并发读取统计，需配合-race运行
+func TestTunQueueLifecycle(t *testing.T) {
+	startTestCore(t, "MATCH,REJECT")
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				FreeTunString(GetTunStats())
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		readers.Wait()
+	}()
+
+	client := netip.MustParseAddr("198.18.0.2")
+	target := netip.MustParseAddr("203.0.113.1")
+	for round := 0; round < 3; round++ {
+		if code := TunAttachPacketFlow(1500); code != CodeSuccess {
+			t.Fatalf("TunAttachPacketFlow = %s", codeName(code))
+		}
+		if code := TunStart(); code != CodeSuccess {
+			t.Fatalf("TunStart = %s", codeName(code))
+		}
+		queue, err := hostQueue()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		port := uint16(40000 + round)
+		for _, packet := range [][]byte{
+			tcpSYN(netip.AddrPortFrom(client, port), netip.AddrPortFrom(target, 80)),
+			udpPacket(netip.AddrPortFrom(client, port), netip.AddrPortFrom(target, 9), []byte("ping")),
+		} {
+			if ok, err := queue.push(packet); !ok || err != nil {
+				t.Fatalf("push = %v, %v", ok, err)
+			}
+		}
+		pullSYNACK(t, queue, port)
+
+		if stats := tunStats.Load().snapshot(); stats.PacketsIn == 0 || stats.PacketsOut == 0 {
+			t.Fatalf("统计未计数: %+v", stats)
+		}
+
+		if code := TunStop(); code != CodeSuccess {
+			t.Fatalf("TunStop = %s", codeName(code))
+		}
+		tunMutex.RLock()
+		report := tunShutdown
+		tunMutex.RUnlock()
+		if report == nil || !report.Completed || report.PendingConnections != 0 {
+			t.Fatalf("lastShutdown = %+v", report)
+		}
+		if _, err := queue.push(tcpSYN(netip.AddrPortFrom(client, port), netip.AddrPortFrom(target, 80))); err == nil {
+			t.Fatal("停止后设备仍可写入")
+		}
+	}
+}